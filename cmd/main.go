@@ -2,24 +2,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 
-	"discordbot/constants/envvar"
+	"discordbot/config"
 	"discordbot/constants/zapkey"
 	"discordbot/debug"
 	"discordbot/discord"
 	discordchannel "discordbot/discord/channel"
-	discordconfig "discordbot/discord/config"
+	"discordbot/ledger"
 	"discordbot/spotify"
+	"discordbot/spotify/worker"
 	"discordbot/utils/httputil"
+	"discordbot/utils/workpool"
 )
 
+// playlistCheckTimeout bounds the startup check that configured playlists are reachable
+const playlistCheckTimeout = 30 * time.Second
+
 type Client interface {
 	fmt.Stringer
 	Start() error
@@ -35,8 +41,8 @@ func main() {
 		}
 	}()
 
-	// Load and validate environment variables
-	loadAndValidateEnv()
+	// Load and validate the configuration
+	cfg := loadConfig()
 
 	// Start the HTTP server
 	port := httputil.Port()
@@ -60,18 +66,57 @@ func main() {
 	}
 	clients = append(clients, debugClient)
 
+	// Open the submission ledger
+	submissionLedger, err := ledger.Open(cfg.Ledger.Path)
+	if err != nil {
+		logger.Fatal("Failed to open ledger", zap.Error(err))
+	}
+	defer func() {
+		if err := submissionLedger.Close(); err != nil {
+			logger.Error("Failed to close ledger", zap.Error(err))
+		}
+	}()
+
 	// Initialize Spotify client
-	spotifyClient, err := spotify.NewClient()
+	spotifyConfig, err := cfg.SpotifyConfig()
+	if err != nil {
+		logger.Fatal("Failed to create Spotify config", zap.Error(err))
+	}
+	spotifyClient, err := spotify.NewClient(
+		spotify.WithConfig(spotifyConfig),
+		spotify.WithLedger(submissionLedger),
+	)
 	if err != nil {
 		logger.Fatal("Failed to create Spotify client", zap.Error(err))
 	}
 
+	// Run the actions for messages on a worker pool, off the Discord event handlers.
+	// Stopped after the Discord client so queued actions finish before Spotify stops.
+	actionPool := workpool.New("Action", cfg.Queue.Workers, cfg.Queue.Size)
+	dispatcher := discord.NewDispatcher(actionPool, cfg.Queue.ActionTimeout)
+	debugClient.AddQueue(actionPool)
+
 	// Initialize Discord client with the spotify client
-	discordClient := newDiscordClient(spotifyClient, readyMessage())
-	clients = append(clients, discordClient)
+	discordClient, messageHandler, readyHandler := newDiscordClient(spotifyClient, dispatcher, cfg)
+	clients = append(clients, discordClient, actionPool)
+
+	// Reload the configuration file on SIGHUP or when it changes, applying message
+	// rules and messages without reconnecting
+	if path := config.Path(); path != "" {
+		watcher := config.NewWatcher(path, cfg, config.DefaultPollInterval)
+		watcher.OnReload(func(_, next *config.File) {
+			messageHandler.SetRules(next.Rules())
+			if err := readyHandler.SetMessages(next.Messages.ReadyMessage(), next.Messages.Listening); err != nil {
+				logger.Warn("Failed to apply reloaded messages", zap.Error(err))
+			}
+		})
+		clients = append(clients, watcher)
+	}
 
-	// Wire Discord health into the debug client's /health endpoint
+	// Wire Discord health into the debug client's /health endpoint, along with the
+	// Cloudflare worker the Spotify client depends on
 	debugClient.SetHealthChecker(discordClient)
+	debugClient.AddDependency("Spotify worker", spotifyClient)
 
 	// Update spotify client with discord messenger
 	spotifyClient.SetMessenger(discordClient)
@@ -99,89 +144,93 @@ func main() {
 
 // --- Helpers ---
 
-func loadAndValidateEnv() {
+// loadConfig loads the configuration file named by CONFIG_FILE, if any, with environment
+// variables (including those in .env) overriding its keys. Every problem found is reported
+// before exiting.
+func loadConfig() *config.File {
 	if err := godotenv.Load(); err != nil {
 		logger.Info("No .env file found or unreadable; proceeding with system environment", zap.Error(err))
 	}
-	required := []string{
-		envvar.DiscordToken,
-		envvar.DiscordAppID,
-		envvar.DiscordAuthChannelID,
-		envvar.DiscordSongsChannelID,
-		envvar.SpotifyPlaylistID,
-		envvar.SpotifyWorkerURL,
-		envvar.CFAccessClientID,
-		envvar.CFAccessClientSecret,
-	}
-	var missing []string
-	for _, v := range required {
-		if os.Getenv(v) == "" {
-			missing = append(missing, v)
-		}
-	}
-	if len(missing) > 0 {
-		logger.Fatal("missing required environment variables",
-			zap.Strings("vars", missing))
-	}
-}
-
-func listeningActivity() string {
-	msg := os.Getenv(envvar.BotListeningMessage)
-	if msg == "" {
-		msg = "song requests"
-	}
-	return msg
-}
-
-func readyMessage() string {
-	msg := os.Getenv(envvar.BotReadyMessage)
-	if msg == "" {
-		msg = "Bot is online. Ready to record your songs."
-	}
-	version := os.Getenv(envvar.BotVersion)
-	if version == "" {
-		version = "Unknown version"
+	path := config.Path()
+	cfg, err := config.Load(path)
+	if err != nil {
+		logger.Fatal("Invalid configuration", zap.Error(err), zap.String(zapkey.Path, path))
 	}
-	return fmt.Sprintf("%s\nVersion: %s", msg, version)
+	return cfg
 }
 
-func newDiscordClient(playlistAdder discord.PlaylistAdder, botReadyMessage string) *discord.Client {
-	config, err := discordconfig.NewConfig()
+func newDiscordClient(spotifyClient *spotify.Client, dispatcher *discord.Dispatcher, cfg *config.File) (*discord.Client, *discord.MessageHandler, *discord.ReadyHandler) {
+	discordConfig, err := cfg.DiscordConfig()
 	if err != nil {
 		logger.Fatal("Failed to create Discord config", zap.Error(err))
 	}
 
-	// Actions to perform when a message is received
-	actions := make(discord.ChannelActions)
-	for channelType, channelID := range config.ChannelIDs {
-		switch channelType {
-		case discordchannel.Songs:
-			// Add tracks to playlist for the Songs channel
-			actions.Add(channelID, discord.ActionAddTracksToPlaylist)
-		case discordchannel.Debug:
-			// Add tracks to playlist and send a reply for the Debug channel
-			actions.Add(channelID, discord.ActionReply)
-			actions.Add(channelID, discord.ActionAddTracksToPlaylist)
-		default:
-			logger.Warn("Skipping unknown channel type",
-				zap.String(zapkey.ChannelType, channelType.String()),
-				zap.String(zapkey.ChannelID, channelID))
+	// Channels to announce the bot in
+	var songsChannelIDs []string
+	for _, guild := range discordConfig.AllGuilds() {
+		if channelID := guild.ChannelIDs[discordchannel.Songs]; channelID != "" {
+			songsChannelIDs = append(songsChannelIDs, channelID)
 		}
 	}
+	checkPlaylists(spotifyClient, cfg.Spotify.AdminUserID, discordConfig.PlaylistIDs())
+
+	// Album and playlist links that would add many tracks wait for the submitter to confirm them
+	confirmations := discord.NewConfirmations(spotifyClient)
+
+	// Slash commands
+	commandList := append(discord.BuiltinCommands(), discord.NewSpotifyCommand(spotifyClient))
+	if cfg.Features.AddCommand {
+		commandList = append(commandList, discord.NewAddCommand(spotifyClient, spotifyClient, discordConfig, confirmations))
+	}
+	commands, err := discord.NewCommands(commandList...)
+	if err != nil {
+		logger.Fatal("Failed to register slash commands", zap.Error(err))
+	}
 
-	songsChannelID := config.ChannelIDs[discordchannel.Songs]
+	// Interactions
+	router := discord.NewRouter(commands)
+	confirmations.Register(router)
 
 	// Handlers
+	readyHandler := discord.NewReadyHandler(songsChannelIDs, cfg.Messages.ReadyMessage(), cfg.Messages.Listening)
+	messageHandler := discord.NewMessageHandler(spotifyClient, spotifyClient, confirmations, discordConfig, cfg.Rules(), dispatcher)
 	handlers := []discord.Handler{
-		discord.NewReadyHandler(songsChannelID, botReadyMessage, listeningActivity()),
-		discord.NewMessageHandler(playlistAdder, actions),
-		discord.NewInteractionSessionHandler(),
+		readyHandler,
+		messageHandler,
+		discord.NewInteractionSessionHandler(router),
 	}
 
 	// Create the client
-	discordClient, err := discord.NewClient(discord.WithHandlers(handlers...))
+	discordClient, err := discord.NewClient(
+		discord.WithConfig(discordConfig),
+		discord.WithHandlers(handlers...),
+		discord.WithCommands(commands),
+		discord.WithConfirmations(confirmations),
+	)
 	if err != nil {
 		logger.Fatal("Failed to create Discord client", zap.Error(err))
 	}
-	return discordClient
+	return discordClient, messageHandler, readyHandler
+}
+
+// checkPlaylists verifies at startup that every playlist submissions can be added to is reachable,
+// using the Spotify session of the admin user. Skipped if no admin user is configured or they
+// have not connected Spotify yet.
+func checkPlaylists(spotifyClient *spotify.Client, adminUserID string, playlistIDs []string) {
+	if adminUserID == "" {
+		logger.Warn("No Spotify admin user configured; skipping playlist check")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), playlistCheckTimeout)
+	defer cancel()
+
+	err := spotifyClient.CheckPlaylists(ctx, adminUserID, playlistIDs)
+	if errors.Is(err, worker.ErrAuthRequired) {
+		logger.Warn("Spotify admin user has not connected Spotify; skipping playlist check",
+			zap.String(zapkey.UserID, adminUserID))
+		return
+	}
+	if err != nil {
+		logger.Fatal("Configured playlists are not reachable", zap.Error(err))
+	}
 }
@@ -0,0 +1,416 @@
+// Package config loads the bot's configuration from a single YAML file, with environment
+// variables overriding individual keys
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"discordbot/constants/envvar"
+	"discordbot/discord/channel"
+	discordconfig "discordbot/discord/config"
+	spotifyconfig "discordbot/spotify/config"
+	"discordbot/spotify/worker"
+)
+
+// File is the configuration file. See config.example.yaml for a documented example.
+type File struct {
+	Discord  Discord  `yaml:"discord"`
+	Spotify  Spotify  `yaml:"spotify"`
+	Messages Messages `yaml:"messages"`
+	Features Features `yaml:"features"`
+	Queue    Queue    `yaml:"queue"`
+	Ledger   Ledger   `yaml:"ledger"`
+}
+
+// Discord configures the Discord connection and the guilds the bot serves
+type Discord struct {
+	Token      string `yaml:"token"`
+	AppID      string `yaml:"app_id"`
+	DevGuildID string `yaml:"dev_guild_id"` // Guild to register slash commands in; global when empty
+
+	// Delete the app's global slash commands when registering them in the dev guild
+	CleanGlobalCommands bool `yaml:"clean_global_commands"`
+
+	// Guild is the configuration of guilds without their own entry in Guilds
+	Guild `yaml:",inline"`
+
+	// Per-guild configuration keyed by guild ID
+	Guilds map[string]Guild `yaml:"guilds"`
+
+	// Rules deciding the actions taken for messages, evaluated before the rules implied by
+	// the channel types. See Rule.
+	Rules []Rule `yaml:"rules"`
+}
+
+// Guild configures the bot in one guild
+type Guild struct {
+	Channels          map[string]string `yaml:"channels"` // Channel ID by type: songs, auth, debug
+	PlaylistID        string            `yaml:"playlist_id"`
+	ChannelPlaylists  map[string]string `yaml:"channel_playlists"`
+	TagPlaylists      map[string]string `yaml:"tag_playlists"`
+	DeleteGracePeriod *time.Duration    `yaml:"delete_grace_period"`
+}
+
+// Spotify configures the Spotify worker and how links are added
+type Spotify struct {
+	WorkerURL            string `yaml:"worker_url"`
+	CFAccessClientID     string `yaml:"cf_access_client_id"`
+	CFAccessClientSecret string `yaml:"cf_access_client_secret"`
+	AdminUserID          string `yaml:"admin_user_id"` // Discord user whose session checks playlists at startup
+
+	WorkerTimeout time.Duration `yaml:"worker_timeout"` // Longest a single call to the worker may take
+	WorkerRetries int           `yaml:"worker_retries"` // Retries of worker calls failing on trouble that may pass
+
+	WorkerBreakerThreshold int           `yaml:"worker_breaker_threshold"` // Consecutive worker failures before calls fail fast; 0 never fails fast
+	WorkerBreakerCooldown  time.Duration `yaml:"worker_breaker_cooldown"`  // How long calls fail fast before the worker is tried again
+
+	MaxAlbumTracks      int     `yaml:"max_album_tracks"`
+	MaxPlaylistTracks   int     `yaml:"max_playlist_tracks"`
+	ConfirmThreshold    int     `yaml:"confirm_threshold"`
+	MetadataProviderURL string  `yaml:"metadata_provider_url"`
+	MatchThreshold      float64 `yaml:"match_threshold"`
+
+	PlaylistCachePath string        `yaml:"playlist_cache_path"` // Keeps playlist contents across restarts; empty keeps them in memory only
+	BatchWindow       time.Duration `yaml:"batch_window"`        // Submissions to a playlist within this window are written together
+
+	RetryQueuePath string        `yaml:"retry_queue_path"` // Keeps submissions waiting to be retried across restarts
+	RetryTTL       time.Duration `yaml:"retry_ttl"`        // How long a submission is retried before giving up; 0 retries forever
+}
+
+// Messages configures what the bot says
+type Messages struct {
+	Ready     string `yaml:"ready"`     // Posted in songs channels when the bot comes online
+	Listening string `yaml:"listening"` // Shown as "Listening to ..." in the bot's presence
+	Version   string `yaml:"version"`   // Appended to the ready message
+}
+
+// Features toggles optional behaviour
+type Features struct {
+	AddCommand     bool `yaml:"add_command"`     // Serve the /add slash command
+	TagPlaylists   bool `yaml:"tag_playlists"`   // Also add hashtagged submissions to the tag's playlist
+	LinkConversion bool `yaml:"link_conversion"` // Convert song links from other platforms
+}
+
+// Queue configures the worker pool that runs the actions for messages
+type Queue struct {
+	Workers       int           `yaml:"workers"`        // Actions run at once
+	Size          int           `yaml:"size"`           // Messages that can wait or run before new ones are turned away
+	ActionTimeout time.Duration `yaml:"action_timeout"` // Longest an action may run; 0 for no limit
+}
+
+// Ledger configures the record of submitted tracks
+type Ledger struct {
+	Path string `yaml:"path"`
+}
+
+// channelTypes maps the channel keys used in the file to channel types
+var channelTypes = map[string]channel.Type{
+	"songs": channel.Songs,
+	"auth":  channel.Auth,
+	"debug": channel.Debug,
+}
+
+// defaults returns the configuration used for keys that are neither in the file nor the environment
+func defaults() *File {
+	return &File{
+		Spotify: Spotify{
+			WorkerTimeout: worker.DefaultTimeout,
+			WorkerRetries: worker.DefaultRetries,
+
+			WorkerBreakerThreshold: worker.DefaultBreakerThreshold,
+			WorkerBreakerCooldown:  worker.DefaultBreakerCooldown,
+
+			MaxAlbumTracks:    spotifyconfig.DefaultMaxAlbumTracks,
+			MaxPlaylistTracks: spotifyconfig.DefaultMaxPlaylistTracks,
+			ConfirmThreshold:  spotifyconfig.DefaultConfirmThreshold,
+			MatchThreshold:    spotifyconfig.DefaultMatchThreshold,
+			BatchWindow:       spotifyconfig.DefaultBatchWindow,
+			RetryQueuePath:    spotifyconfig.DefaultRetryQueuePath,
+			RetryTTL:          spotifyconfig.DefaultRetryTTL,
+		},
+		Messages: Messages{
+			Ready:     "Bot is online. Ready to record your songs.",
+			Listening: "song requests",
+			Version:   "Unknown version",
+		},
+		Features: Features{
+			AddCommand:     true,
+			TagPlaylists:   true,
+			LinkConversion: true,
+		},
+		Queue: Queue{
+			Workers:       4,
+			Size:          100,
+			ActionTimeout: 2 * time.Minute,
+		},
+		Ledger: Ledger{Path: "data/ledger.jsonl"},
+	}
+}
+
+// Load reads the configuration file at path, applies environment variable overrides and
+// validates the result. An empty path loads the configuration from the environment alone.
+// Every problem found is reported in the returned error.
+func Load(path string) (*File, error) {
+	f := defaults()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(f); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+	if err := errors.Join(f.applyEnv(), f.Validate()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the path of the configuration file given by the environment, or "" if there is none
+func Path() string {
+	return os.Getenv(envvar.ConfigFile)
+}
+
+// Validate checks the whole configuration, reporting every problem at once
+func (f *File) Validate() error {
+	var errs []error
+	problem := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	// Discord
+	if f.Discord.Token == "" {
+		problem("discord.token is not set")
+	}
+	if f.Discord.AppID == "" {
+		problem("discord.app_id is not set")
+	}
+	if len(f.Discord.Guilds) > 0 && os.Getenv(envvar.DiscordGuildsFile) != "" {
+		// Neither may silently win over the other
+		problem("discord.guilds and %s are both set; configure guilds in only one of them", envvar.DiscordGuildsFile)
+	}
+	errs = append(errs, f.Discord.Guild.validate("discord", len(f.Discord.Guilds) == 0)...)
+	for _, guildID := range slices.Sorted(maps.Keys(f.Discord.Guilds)) {
+		guild := f.Discord.Guilds[guildID]
+		errs = append(errs, guild.validate(fmt.Sprintf("discord.guilds.%s", guildID), true)...)
+	}
+	for i, rule := range f.Discord.Rules {
+		errs = append(errs, rule.validate(fmt.Sprintf("discord.rules[%d]", i))...)
+	}
+
+	// Spotify
+	required := map[string]string{
+		"worker_url":              f.Spotify.WorkerURL,
+		"cf_access_client_id":     f.Spotify.CFAccessClientID,
+		"cf_access_client_secret": f.Spotify.CFAccessClientSecret,
+	}
+	for _, key := range slices.Sorted(maps.Keys(required)) {
+		if required[key] == "" {
+			problem("spotify.%s is not set", key)
+		}
+	}
+	if f.Spotify.WorkerTimeout <= 0 {
+		problem("spotify.worker_timeout must be positive")
+	}
+	if f.Spotify.WorkerBreakerCooldown <= 0 {
+		problem("spotify.worker_breaker_cooldown must be positive")
+	}
+	limits := map[string]int{
+		"worker_retries":           f.Spotify.WorkerRetries,
+		"worker_breaker_threshold": f.Spotify.WorkerBreakerThreshold,
+		"max_album_tracks":         f.Spotify.MaxAlbumTracks,
+		"max_playlist_tracks":      f.Spotify.MaxPlaylistTracks,
+		"confirm_threshold":        f.Spotify.ConfirmThreshold,
+	}
+	for _, key := range slices.Sorted(maps.Keys(limits)) {
+		if limits[key] < 0 {
+			problem("spotify.%s must not be negative", key)
+		}
+	}
+	if f.Spotify.BatchWindow < 0 {
+		problem("spotify.batch_window must not be negative")
+	}
+	if f.Spotify.RetryTTL < 0 {
+		problem("spotify.retry_ttl must not be negative")
+	}
+	if f.Spotify.MatchThreshold < 0 || f.Spotify.MatchThreshold > 1 {
+		problem("spotify.match_threshold must be between 0 and 1")
+	}
+	if f.Spotify.MetadataProviderURL != "" {
+		if u, err := url.Parse(f.Spotify.MetadataProviderURL); err != nil || u.Host == "" {
+			problem("spotify.metadata_provider_url is not a valid URL")
+		}
+	}
+
+	// Queue
+	if f.Queue.Workers < 1 {
+		problem("queue.workers must be at least 1")
+	}
+	if f.Queue.Size < 1 {
+		problem("queue.size must be at least 1")
+	}
+	if f.Queue.ActionTimeout < 0 {
+		problem("queue.action_timeout must not be negative")
+	}
+
+	// Ledger
+	if f.Ledger.Path == "" {
+		problem("ledger.path is not set")
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks a guild's configuration. If complete is set, the channels and playlist
+// every guild needs must also be set.
+func (g *Guild) validate(key string, complete bool) []error {
+	var errs []error
+	problem := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(key+"."+format, args...))
+	}
+	for _, name := range slices.Sorted(maps.Keys(g.Channels)) {
+		channelID := g.Channels[name]
+		if _, ok := channelTypes[name]; !ok {
+			problem("channels: unknown channel type %q", name)
+		}
+		if channelID == "" {
+			problem("channels.%s is empty", name)
+		}
+	}
+	for _, channelID := range slices.Sorted(maps.Keys(g.ChannelPlaylists)) {
+		if playlistID := g.ChannelPlaylists[channelID]; channelID == "" || playlistID == "" {
+			problem("channel_playlists: mapping %q: %q is incomplete", channelID, playlistID)
+		}
+	}
+	for _, tag := range slices.Sorted(maps.Keys(g.TagPlaylists)) {
+		if playlistID := g.TagPlaylists[tag]; tag == "" || playlistID == "" {
+			problem("tag_playlists: mapping %q: %q is incomplete", tag, playlistID)
+		}
+	}
+	if g.DeleteGracePeriod != nil && *g.DeleteGracePeriod < 0 {
+		problem("delete_grace_period must not be negative")
+	}
+	if !complete {
+		return errs
+	}
+	for _, name := range []string{"songs", "auth"} {
+		if g.Channels[name] == "" {
+			problem("channels.%s is not set", name)
+		}
+	}
+	if g.PlaylistID == "" && len(g.ChannelPlaylists) == 0 {
+		problem("playlist_id is not set")
+	}
+	return errs
+}
+
+// --- Client Configuration ---
+
+// DiscordConfig returns the configuration of the Discord client. Guilds come from the guilds
+// section, or from the guilds file if there is none; Validate rejects setting both.
+func (f *File) DiscordConfig() (*discordconfig.Config, error) {
+	defaults := f.Discord.Guild.guildConfig(discordconfig.GuildConfig{})
+	cfg := &discordconfig.Config{
+		Token:       f.Discord.Token,
+		AppID:       f.Discord.AppID,
+		DevGuildID:  f.Discord.DevGuildID,
+		GuildConfig: *defaults,
+
+		CleanGlobalCommands: f.Discord.CleanGlobalCommands,
+	}
+	if len(f.Discord.Guilds) > 0 {
+		cfg.Guilds = make(map[string]*discordconfig.GuildConfig, len(f.Discord.Guilds))
+		for guildID, guild := range f.Discord.Guilds {
+			cfg.Guilds[guildID] = guild.guildConfig(*defaults)
+		}
+	} else if path := os.Getenv(envvar.DiscordGuildsFile); path != "" {
+		guilds, err := discordconfig.LoadGuilds(path, *defaults)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load guilds file: %w", err)
+		}
+		cfg.Guilds = guilds
+	}
+	if !f.Features.TagPlaylists {
+		cfg.TagPlaylists = nil
+		for _, guild := range cfg.Guilds {
+			guild.TagPlaylists = nil
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid discord configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// guildConfig converts a guild's configuration, taking unset settings from defaults
+func (g *Guild) guildConfig(defaults discordconfig.GuildConfig) *discordconfig.GuildConfig {
+	guild := &discordconfig.GuildConfig{
+		ChannelIDs:        make(map[channel.Type]string, len(g.Channels)),
+		DeleteGracePeriod: defaults.DeleteGracePeriod,
+		ChannelPlaylists:  g.ChannelPlaylists,
+		DefaultPlaylistID: g.PlaylistID,
+		TagPlaylists:      make(map[string]string, len(g.TagPlaylists)),
+	}
+	for name, channelID := range g.Channels {
+		guild.ChannelIDs[channelTypes[name]] = channelID
+	}
+	for tag, playlistID := range g.TagPlaylists {
+		guild.TagPlaylists[discordconfig.NormalizeTag(tag)] = playlistID
+	}
+	if guild.DefaultPlaylistID == "" {
+		guild.DefaultPlaylistID = defaults.DefaultPlaylistID
+	}
+	if g.DeleteGracePeriod != nil {
+		guild.DeleteGracePeriod = *g.DeleteGracePeriod
+	}
+	return guild
+}
+
+// SpotifyConfig returns the configuration of the Spotify client
+func (f *File) SpotifyConfig() (*spotifyconfig.Config, error) {
+	cfg := &spotifyconfig.Config{
+		WorkerURL:            f.Spotify.WorkerURL,
+		CFAccessClientID:     f.Spotify.CFAccessClientID,
+		CFAccessClientSecret: f.Spotify.CFAccessClientSecret,
+		WorkerTimeout:        f.Spotify.WorkerTimeout,
+		WorkerRetries:        f.Spotify.WorkerRetries,
+
+		WorkerBreakerThreshold: f.Spotify.WorkerBreakerThreshold,
+		WorkerBreakerCooldown:  f.Spotify.WorkerBreakerCooldown,
+
+		PlaylistID:          f.Discord.PlaylistID,
+		MaxAlbumTracks:      f.Spotify.MaxAlbumTracks,
+		MaxPlaylistTracks:   f.Spotify.MaxPlaylistTracks,
+		ConfirmThreshold:    f.Spotify.ConfirmThreshold,
+		MetadataProviderURL: f.Spotify.MetadataProviderURL,
+		MatchThreshold:      f.Spotify.MatchThreshold,
+		PlaylistCachePath:   f.Spotify.PlaylistCachePath,
+		BatchWindow:         f.Spotify.BatchWindow,
+		RetryQueuePath:      f.Spotify.RetryQueuePath,
+		RetryTTL:            f.Spotify.RetryTTL,
+	}
+	if !f.Features.LinkConversion {
+		cfg.MetadataProviderURL = ""
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid spotify configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// ReadyMessage returns the message posted when the bot comes online
+func (m Messages) ReadyMessage() string {
+	return fmt.Sprintf("%s\nVersion: %s", m.Ready, m.Version)
+}
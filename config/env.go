@@ -0,0 +1,163 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"discordbot/constants/envvar"
+	discordconfig "discordbot/discord/config"
+)
+
+// envOverrides maps each environment variable to a setter for the configuration key it overrides
+func (f *File) envOverrides() map[string]func(string) error {
+	return map[string]func(string) error{
+		// Discord
+		envvar.DiscordToken:               setString(&f.Discord.Token),
+		envvar.DiscordAppID:               setString(&f.Discord.AppID),
+		envvar.DiscordDevGuildID:          setString(&f.Discord.DevGuildID),
+		envvar.DiscordCleanGlobalCommands: setBool(&f.Discord.CleanGlobalCommands),
+		envvar.DiscordSongsChannelID:      f.Discord.setChannel("songs"),
+		envvar.DiscordAuthChannelID:       f.Discord.setChannel("auth"),
+		envvar.DiscordDebugChannelID:      f.Discord.setChannel("debug"),
+		envvar.DiscordDeleteGracePeriod:   setOptionalDuration(&f.Discord.DeleteGracePeriod),
+		envvar.DiscordChannelPlaylists:    setMapping(&f.Discord.ChannelPlaylists),
+		envvar.DiscordTagPlaylists:        setMapping(&f.Discord.TagPlaylists),
+		envvar.SpotifyPlaylistID:          setString(&f.Discord.PlaylistID),
+
+		// Spotify
+		envvar.SpotifyWorkerURL:              setString(&f.Spotify.WorkerURL),
+		envvar.CFAccessClientID:              setString(&f.Spotify.CFAccessClientID),
+		envvar.CFAccessClientSecret:          setString(&f.Spotify.CFAccessClientSecret),
+		envvar.SpotifyAdminUserID:            setString(&f.Spotify.AdminUserID),
+		envvar.SpotifyWorkerTimeout:          setDuration(&f.Spotify.WorkerTimeout),
+		envvar.SpotifyWorkerRetries:          setInt(&f.Spotify.WorkerRetries),
+		envvar.SpotifyWorkerBreakerThreshold: setInt(&f.Spotify.WorkerBreakerThreshold),
+		envvar.SpotifyWorkerBreakerCooldown:  setDuration(&f.Spotify.WorkerBreakerCooldown),
+		envvar.SpotifyMaxAlbumTracks:         setInt(&f.Spotify.MaxAlbumTracks),
+		envvar.SpotifyMaxPlaylistTracks:      setInt(&f.Spotify.MaxPlaylistTracks),
+		envvar.SpotifyConfirmThreshold:       setInt(&f.Spotify.ConfirmThreshold),
+		envvar.MetadataProviderURL:           setString(&f.Spotify.MetadataProviderURL),
+		envvar.SpotifyMatchThreshold:         setFloat(&f.Spotify.MatchThreshold),
+		envvar.SpotifyPlaylistCachePath:      setString(&f.Spotify.PlaylistCachePath),
+		envvar.SpotifyBatchWindow:            setDuration(&f.Spotify.BatchWindow),
+		envvar.SpotifyRetryQueuePath:         setString(&f.Spotify.RetryQueuePath),
+		envvar.SpotifyRetryTTL:               setDuration(&f.Spotify.RetryTTL),
+
+		// Messages
+		envvar.BotReadyMessage:     setString(&f.Messages.Ready),
+		envvar.BotListeningMessage: setString(&f.Messages.Listening),
+		envvar.BotVersion:          setString(&f.Messages.Version),
+
+		// Queue
+		envvar.ActionWorkers:   setInt(&f.Queue.Workers),
+		envvar.ActionQueueSize: setInt(&f.Queue.Size),
+		envvar.ActionTimeout:   setDuration(&f.Queue.ActionTimeout),
+
+		// Ledger
+		envvar.LedgerPath: setString(&f.Ledger.Path),
+	}
+}
+
+// applyEnv overrides configuration keys with the environment variables that are set
+func (f *File) applyEnv() error {
+	var errs []error
+	for key, set := range f.envOverrides() {
+		val := os.Getenv(key)
+		if val == "" {
+			continue
+		}
+		if err := set(val); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// --- Setters ---
+
+func setString(field *string) func(string) error {
+	return func(val string) error {
+		*field = val
+		return nil
+	}
+}
+
+func setInt(field *int) func(string) error {
+	return func(val string) error {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		*field = n
+		return nil
+	}
+}
+
+func setBool(field *bool) func(string) error {
+	return func(val string) error {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		*field = b
+		return nil
+	}
+}
+
+func setFloat(field *float64) func(string) error {
+	return func(val string) error {
+		n, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		*field = n
+		return nil
+	}
+}
+
+func setOptionalDuration(field **time.Duration) func(string) error {
+	return func(val string) error {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		*field = &d
+		return nil
+	}
+}
+
+func setDuration(field *time.Duration) func(string) error {
+	return func(val string) error {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		*field = d
+		return nil
+	}
+}
+
+func setMapping(field *map[string]string) func(string) error {
+	return func(val string) error {
+		mapping, err := discordconfig.ParseMapping(val)
+		if err != nil {
+			return err
+		}
+		*field = mapping
+		return nil
+	}
+}
+
+// setChannel sets the ID of the default guild's channel of the given type
+func (d *Discord) setChannel(name string) func(string) error {
+	return func(val string) error {
+		if d.Channels == nil {
+			d.Channels = make(map[string]string)
+		}
+		d.Channels[name] = val
+		return nil
+	}
+}
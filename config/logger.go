@@ -0,0 +1,7 @@
+package config
+
+import (
+	"discordbot/log"
+)
+
+var logger = log.Logger.Named("config")
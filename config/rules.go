@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"discordbot/discord"
+)
+
+// Rule triggers actions for the messages it matches. Rules are evaluated in order and the
+// first matching rule applies, unless it sets continue.
+//
+//	rules:
+//	  - name: gym
+//	    match:
+//	      channels: ["123456789012345678"]
+//	      roles: ["234567890123456789"]
+//	      content: "(?i)#gym"
+//	    actions:
+//	      - action: Add Tracks To Playlist
+//	        params: {playlist: 37i9dQZF1DX76Wlfdnj7AP}
+//	      - action: Reply
+//	        params: {message: Added to the gym playlist}
+type Rule struct {
+	Name     string       `yaml:"name"`
+	Match    RuleMatch    `yaml:"match"`
+	Actions  []RuleAction `yaml:"actions"`
+	Continue bool         `yaml:"continue"` // Also evaluate the rules after this one when it matches
+}
+
+// RuleMatch describes the messages a rule applies to. Unset conditions match every message.
+type RuleMatch struct {
+	Channels    []string `yaml:"channels"`    // Message is in one of these channels, or in a thread of one
+	Roles       []string `yaml:"roles"`       // Author has at least one of these roles
+	Content     string   `yaml:"content"`     // Regular expression the message content matches
+	Attachments *bool    `yaml:"attachments"` // Message has (true) or has no (false) attachments
+	Thread      *bool    `yaml:"thread"`      // Message is (true) or is not (false) in a thread
+}
+
+// RuleAction is an action a rule triggers, by registered name, with its parameters
+type RuleAction struct {
+	Name   string            `yaml:"action"`
+	Params map[string]string `yaml:"params"`
+}
+
+// validate checks a rule, reporting its problems under key
+func (r *Rule) validate(key string) []error {
+	var errs []error
+	problem := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(key+"."+format, args...))
+	}
+	if r.Match.Content != "" {
+		if _, err := regexp.Compile(r.Match.Content); err != nil {
+			problem("match.content is not a valid regular expression: %v", err)
+		}
+	}
+	if len(r.Actions) == 0 {
+		problem("actions is empty")
+	}
+	for i, action := range r.Actions {
+		if !discord.ActionRegistered(action.Name) {
+			problem("actions[%d]: unknown action %q", i, action.Name)
+		}
+	}
+	return errs
+}
+
+// Rules returns the rules messages are evaluated against: the configured rules, followed by
+// those implied by the channel types. Songs channels add tracks, debug channels also reply,
+// and channels with their own playlist add tracks. Threads of these channels are left alone.
+func (f *File) Rules() discord.Rules {
+	var rules discord.Rules
+	for _, rule := range f.Discord.Rules {
+		rules = append(rules, rule.rule())
+	}
+
+	guilds := []Guild{f.Discord.Guild}
+	if len(f.Discord.Guilds) > 0 {
+		guilds = guilds[:0]
+		for _, guildID := range slices.Sorted(maps.Keys(f.Discord.Guilds)) {
+			guilds = append(guilds, f.Discord.Guilds[guildID])
+		}
+	}
+	notThread := false
+	channelRule := func(name, channelID string, actions ...string) {
+		rule := discord.Rule{
+			Name:  name,
+			Match: discord.Match{ChannelIDs: []string{channelID}, Thread: &notThread},
+		}
+		for _, action := range actions {
+			rule.Actions = append(rule.Actions, discord.RuleAction{Name: action})
+		}
+		rules = append(rules, rule)
+	}
+	for _, guild := range guilds {
+		songsChannelID, debugChannelID := guild.Channels["songs"], guild.Channels["debug"]
+		if songsChannelID != "" {
+			channelRule("songs", songsChannelID, discord.ActionAddTracksToPlaylist)
+		}
+		if debugChannelID != "" {
+			channelRule("debug", debugChannelID, discord.ActionReply, discord.ActionAddTracksToPlaylist)
+		}
+		for _, channelID := range slices.Sorted(maps.Keys(guild.ChannelPlaylists)) {
+			if channelID != songsChannelID && channelID != debugChannelID {
+				channelRule("channel playlist", channelID, discord.ActionAddTracksToPlaylist)
+			}
+		}
+	}
+	return rules
+}
+
+// rule converts a configured rule. The rule must be valid.
+func (r *Rule) rule() discord.Rule {
+	rule := discord.Rule{
+		Name: r.Name,
+		Match: discord.Match{
+			ChannelIDs:  r.Match.Channels,
+			RoleIDs:     r.Match.Roles,
+			Attachments: r.Match.Attachments,
+			Thread:      r.Match.Thread,
+		},
+		Continue: r.Continue,
+	}
+	if r.Match.Content != "" {
+		rule.Match.Content = regexp.MustCompile(r.Match.Content)
+	}
+	for _, action := range r.Actions {
+		rule.Actions = append(rule.Actions, discord.RuleAction{Name: action.Name, Params: action.Params})
+	}
+	return rule
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+)
+
+// DefaultPollInterval is how often the watcher checks the configuration file for changes
+const DefaultPollInterval = 10 * time.Second
+
+// ReloadFunc applies a reloaded configuration. It is only called with valid configurations.
+type ReloadFunc func(old, new *File)
+
+// Watcher reloads the configuration file when the process receives SIGHUP or the file changes.
+//
+// Only changes to rules and messages are applied while the bot is running; the bot
+// keeps its connections, so every other change is logged as needing a restart. Invalid
+// configurations are rejected and the current one kept, as are configurations changing
+// channels: the rules implied by channel types would otherwise disagree with the channels
+// the rest of the bot still uses.
+type Watcher struct {
+	path     string
+	interval time.Duration
+
+	mu       sync.Mutex
+	current  *File
+	modTime  time.Time
+	onReload []ReloadFunc
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWatcher creates a watcher for the configuration file at path, currently loaded as current
+func NewWatcher(path string, current *File, interval time.Duration) *Watcher {
+	w := &Watcher{path: path, interval: interval, current: current}
+	if info, err := os.Stat(path); err == nil {
+		w.modTime = info.ModTime()
+	}
+	return w
+}
+
+// String returns a string representation of the watcher
+func (w *Watcher) String() string {
+	return "Config Watcher"
+}
+
+// OnReload registers fn to be called with each valid reloaded configuration
+func (w *Watcher) OnReload(fn ReloadFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onReload = append(w.onReload, fn)
+}
+
+// Current returns the configuration currently in effect
+func (w *Watcher) Current() *File {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Start watches for SIGHUP and changes to the file in the background
+func (w *Watcher) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	go func() {
+		defer close(w.done)
+		defer signal.Stop(hangups)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				logger.Info("Received SIGHUP; reloading configuration", zap.String(zapkey.Path, w.path))
+				w.reload()
+			case <-ticker.C:
+				if w.changed() {
+					logger.Info("Configuration file changed; reloading", zap.String(zapkey.Path, w.path))
+					w.reload()
+				}
+			}
+		}
+	}()
+	logger.Info("Watching configuration file", zap.String(zapkey.Path, w.path))
+	return nil
+}
+
+// Stop stops watching
+func (w *Watcher) Stop() error {
+	if w.cancel == nil {
+		return nil
+	}
+	w.cancel()
+	<-w.done
+	return nil
+}
+
+// changed reports whether the file was modified since it was last loaded
+func (w *Watcher) changed() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !info.ModTime().Equal(w.modTime)
+}
+
+// reload loads the file and applies it if it is valid
+func (w *Watcher) reload() {
+	if info, err := os.Stat(w.path); err == nil {
+		w.mu.Lock()
+		w.modTime = info.ModTime()
+		w.mu.Unlock()
+	}
+
+	next, err := Load(w.path)
+	if err != nil {
+		logger.With(zap.Error(err)).Error("Invalid configuration; keeping the current one", zap.String(zapkey.Path, w.path))
+		return
+	}
+
+	w.mu.Lock()
+	old := w.current
+	keys := restartRequired(old, next)
+	var channels []string
+	for _, key := range keys {
+		if slices.Contains(channelKeys, key) {
+			channels = append(channels, key)
+		}
+	}
+	if len(channels) > 0 {
+		w.mu.Unlock()
+		logger.Error("Channel changes require a restart; keeping the current configuration",
+			zap.Strings(zapkey.Keys, channels), zap.String(zapkey.Path, w.path))
+		return
+	}
+	w.current = next
+	callbacks := append([]ReloadFunc(nil), w.onReload...)
+	w.mu.Unlock()
+
+	if len(keys) > 0 {
+		logger.Warn("Some configuration changes take effect after a restart", zap.Strings(zapkey.Keys, keys))
+	}
+	for _, fn := range callbacks {
+		fn(old, next)
+	}
+	logger.Info("Reloaded configuration", zap.String(zapkey.Path, w.path))
+}
+
+// channelKeys are the keys the rules implied by channel types are derived from
+var channelKeys = []string{"discord.channels", "discord.channel_playlists", "discord.guilds"}
+
+// restartRequired returns the keys that changed between old and new but are only read at startup
+func restartRequired(old, new *File) []string {
+	var keys []string
+	check := func(key string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			keys = append(keys, key)
+		}
+	}
+	check("discord.token", old.Discord.Token, new.Discord.Token)
+	check("discord.app_id", old.Discord.AppID, new.Discord.AppID)
+	check("discord.dev_guild_id", old.Discord.DevGuildID, new.Discord.DevGuildID)
+	check("discord.clean_global_commands", old.Discord.CleanGlobalCommands, new.Discord.CleanGlobalCommands)
+	check("discord.channels", old.Discord.Channels, new.Discord.Channels)
+	check("discord.playlist_id", old.Discord.PlaylistID, new.Discord.PlaylistID)
+	check("discord.channel_playlists", old.Discord.ChannelPlaylists, new.Discord.ChannelPlaylists)
+	check("discord.tag_playlists", old.Discord.TagPlaylists, new.Discord.TagPlaylists)
+	check("discord.delete_grace_period", old.Discord.DeleteGracePeriod, new.Discord.DeleteGracePeriod)
+	check("discord.guilds", old.Discord.Guilds, new.Discord.Guilds)
+	check("spotify", old.Spotify, new.Spotify)
+	check("features", old.Features, new.Features)
+	check("queue", old.Queue, new.Queue)
+	check("ledger", old.Ledger, new.Ledger)
+	return keys
+}
@@ -3,10 +3,25 @@ package envvar
 
 // General constants
 const (
-	VerboseLogsEnabled = "VERBOSE_LOGS_ENABLED"
-	BotVersion         = "BOT_VERSION"
-	BotReadyMessage    = "BOT_READY_MESSAGE"
+	VerboseLogsEnabled  = "VERBOSE_LOGS_ENABLED"
+	BotVersion          = "BOT_VERSION"
+	BotReadyMessage     = "BOT_READY_MESSAGE"
 	BotListeningMessage = "BOT_LISTENING_MESSAGE"
+
+	// Path of the configuration file; environment variables override the keys it sets
+	ConfigFile = "CONFIG_FILE"
+)
+
+// Action queue constants
+const (
+	ActionWorkers   = "ACTION_WORKERS"
+	ActionQueueSize = "ACTION_QUEUE_SIZE"
+	ActionTimeout   = "ACTION_TIMEOUT"
+)
+
+// Storage-related constants
+const (
+	LedgerPath = "LEDGER_PATH"
 )
 
 // HTTP-related constants
@@ -20,16 +35,54 @@ const (
 	DiscordAppID = "DISCORD_APP_ID"
 	DiscordToken = "DISCORD_TOKEN"
 
+	// Guild to register slash commands in during development; commands are global when unset
+	DiscordDevGuildID = "DISCORD_DEV_GUILD_ID"
+
+	// Delete global slash commands when registering them in the dev guild
+	DiscordCleanGlobalCommands = "DISCORD_CLEAN_GLOBAL_COMMANDS"
+
 	// Channel IDs
 	DiscordAuthChannelID  = "DISCORD_AUTH_CHANNEL_ID"
 	DiscordDebugChannelID = "DISCORD_DEBUG_CHANNEL_ID"
 	DiscordSongsChannelID = "DISCORD_SONGS_CHANNEL_ID"
+
+	// Message handling
+	DiscordDeleteGracePeriod = "DISCORD_DELETE_GRACE_PERIOD"
+
+	// Playlist routing
+	DiscordChannelPlaylists = "DISCORD_CHANNEL_PLAYLISTS"
+	DiscordTagPlaylists     = "DISCORD_TAG_PLAYLISTS"
+
+	// Per-guild configuration
+	DiscordGuildsFile = "DISCORD_GUILDS_FILE"
 )
 
 // Spotify-related constants
 const (
-	SpotifyPlaylistID = "SPOTIFY_PLAYLIST_ID"
-	SpotifyWorkerURL  = "SPOTIFY_WORKER_URL"
+	SpotifyPlaylistID  = "SPOTIFY_PLAYLIST_ID"
+	SpotifyWorkerURL   = "SPOTIFY_WORKER_URL"
+	SpotifyAdminUserID = "SPOTIFY_ADMIN_USER_ID"
+
+	// Cloudflare Worker calls
+	SpotifyWorkerTimeout          = "SPOTIFY_WORKER_TIMEOUT"
+	SpotifyWorkerRetries          = "SPOTIFY_WORKER_RETRIES"
+	SpotifyWorkerBreakerThreshold = "SPOTIFY_WORKER_BREAKER_THRESHOLD"
+	SpotifyWorkerBreakerCooldown  = "SPOTIFY_WORKER_BREAKER_COOLDOWN"
+
+	// Album and playlist links
+	SpotifyMaxAlbumTracks    = "SPOTIFY_MAX_ALBUM_TRACKS"
+	SpotifyMaxPlaylistTracks = "SPOTIFY_MAX_PLAYLIST_TRACKS"
+	SpotifyConfirmThreshold  = "SPOTIFY_CONFIRM_THRESHOLD"
+
+	// Links from other platforms
+	MetadataProviderURL   = "METADATA_PROVIDER_URL"
+	SpotifyMatchThreshold = "SPOTIFY_MATCH_THRESHOLD"
+
+	// Playlist contents
+	SpotifyPlaylistCachePath = "SPOTIFY_PLAYLIST_CACHE_PATH"
+	SpotifyBatchWindow       = "SPOTIFY_BATCH_WINDOW"
+	SpotifyRetryQueuePath    = "SPOTIFY_RETRY_QUEUE_PATH"
+	SpotifyRetryTTL          = "SPOTIFY_RETRY_TTL"
 )
 
 // Cloudflare worker access
@@ -1,12 +1,7 @@
-// Package channelid defines discord channel ID constants
+// Package id defines discord user ID constants.
+// Channel IDs are configured per guild; see discord/config.
 package id
 
-const (
-	ChannelIDBangers    = "1145402764445372457"
-	ChannelIDBotChannel = "1145402632609992726"
-	ChannelIDTest       = "1332811239461748786"
-)
-
 const (
 	UserIDGio    = "473239680238878720"
 	UserIDJustin = "189960488971403264"
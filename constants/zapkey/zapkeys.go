@@ -2,24 +2,33 @@ package zapkey
 
 // General Keys
 const (
-	Client   = "client"
-	Count    = "count"
-	Data     = "data"
-	ID       = "id"
-	Name     = "name"
-	Result   = "result"
-	Scopes   = "scopes"
-	Type     = "type"
-	UserID   = "user_id"
-	UserName = "user"
+	Client    = "client"
+	Count     = "count"
+	Data      = "data"
+	Duration  = "duration"
+	ID        = "id"
+	Keys      = "keys"
+	Kind      = "kind"
+	Line      = "line"
+	Name      = "name"
+	Next      = "next"
+	Operation = "operation"
+	Requests  = "requests"
+	Result    = "result"
+	Scopes    = "scopes"
+	Type      = "type"
+	UserID    = "user_id"
+	UserName  = "user"
 )
 
 // HTTP Request Keys
 const (
-	Method = "method"
-	Path   = "path"
-	Port   = "port"
-	URL    = "url"
+	Attempt    = "attempt"
+	Method     = "method"
+	Path       = "path"
+	Port       = "port"
+	StatusCode = "status_code"
+	URL        = "url"
 )
 
 // Discord Interaction Keys
@@ -30,11 +39,18 @@ const (
 	ChannelID       = "channel_id"
 	ChannelType     = "channel_type"
 	Command         = "command"
+	Confidence      = "confidence"
 	Content         = "content"
+	CustomID        = "custom_id"
+	GuildID         = "guild_id"
 	Message         = "message"
+	MessageID       = "message_id"
 	PlaylistID      = "playlist_id"
 	PlaylistOwnerID = "playlist_owner_id"
+	Query           = "query"
 	Reply           = "reply"
+	Status          = "status"
+	Tag             = "tag"
 	TrackID         = "track_id"
 	TrackIDs        = "track_ids"
 	TrackURLs       = "track_urls"
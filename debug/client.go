@@ -2,14 +2,17 @@
 package debug
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
 	"go.uber.org/zap"
 
 	"discordbot/constants/envvar"
 	"discordbot/constants/zapkey"
+	"discordbot/utils/workpool"
 )
 
 // HealthChecker reports whether a dependent service is healthy.
@@ -17,9 +20,22 @@ type HealthChecker interface {
 	Healthy() bool
 }
 
+// QueueReporter reports the activity of a work queue.
+type QueueReporter interface {
+	Stats() workpool.Stats
+}
+
+// dependency is a service the bot relies on but can run without for a while
+type dependency struct {
+	name    string
+	checker HealthChecker
+}
+
 // Client for debugging this service
 type Client struct {
 	healthChecker HealthChecker
+	dependencies  []dependency
+	queues        []QueueReporter
 }
 
 // NewClient creates a new debug client
@@ -32,6 +48,17 @@ func (c *Client) SetHealthChecker(hc HealthChecker) {
 	c.healthChecker = hc
 }
 
+// AddDependency adds a service to the /health endpoint. Unlike the health checker, an unhealthy
+// dependency reports the bot as degraded rather than down.
+func (c *Client) AddDependency(name string, hc HealthChecker) {
+	c.dependencies = append(c.dependencies, dependency{name: name, checker: hc})
+}
+
+// AddQueue adds a work queue to the /queues endpoint.
+func (c *Client) AddQueue(q QueueReporter) {
+	c.queues = append(c.queues, q)
+}
+
 func (c *Client) String() string {
 	return "Debug Client"
 }
@@ -41,6 +68,7 @@ func (c *Client) Start() error {
 	// Register the handler function for the default route
 	http.HandleFunc("/", homeHandler)
 	http.HandleFunc("/health", c.healthHandler)
+	http.HandleFunc("/queues", c.queuesHandler)
 	http.HandleFunc("/test", testEndpointHandler)
 	return nil
 }
@@ -64,7 +92,8 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // healthHandler handles the health check route.
-// Returns 200 if the health checker reports healthy, 503 otherwise.
+// Returns 200 if the health checker reports healthy, 503 otherwise. Unhealthy dependencies are
+// listed in the body of a 200 as degraded.
 func (c *Client) healthHandler(w http.ResponseWriter, r *http.Request) {
 	if c.healthChecker == nil || !c.healthChecker.Healthy() {
 		w.WriteHeader(http.StatusServiceUnavailable)
@@ -73,9 +102,32 @@ func (c *Client) healthHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	logger.Info("Health check")
+	var unhealthy []string
+	for _, dep := range c.dependencies {
+		if !dep.checker.Healthy() {
+			unhealthy = append(unhealthy, dep.name)
+		}
+	}
+	status := "OK"
+	if len(unhealthy) > 0 {
+		status = "DEGRADED: unavailable: " + strings.Join(unhealthy, ", ")
+	}
+	logger.Info("Health check", zap.String(zapkey.Status, status))
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(w, status); err != nil {
+		logger.Error("Failed to write response", zap.Error(err), zap.String(zapkey.Path, r.URL.Path))
+	}
+}
+
+// queuesHandler handles the queues route, reporting the depth and activity of each work queue as JSON.
+func (c *Client) queuesHandler(w http.ResponseWriter, r *http.Request) {
+	stats := make([]workpool.Stats, 0, len(c.queues))
+	for _, q := range c.queues {
+		stats = append(stats, q.Stats())
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if _, err := fmt.Fprintf(w, "OK"); err != nil {
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
 		logger.Error("Failed to write response", zap.Error(err), zap.String(zapkey.Path, r.URL.Path))
 	}
 }
@@ -1,6 +1,12 @@
 package discord
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
 
 type Action interface {
 	Execute(ctx context.Context)
@@ -8,16 +14,73 @@ type Action interface {
 
 type Actions []Action
 
-// ChannelActions is a map of channel IDs to action names
-type ChannelActions map[string][]string
+// ActionRequest is what an action is created from when a rule triggers it
+type ActionRequest struct {
+	Session *discordgo.Session
+	Event   *discordgo.MessageCreate
+	Params  map[string]string // Parameters given by the rule
+
+	handler *MessageHandler // Dependencies of the built-in actions
+}
+
+// ActionFactory creates the action a rule triggers for a message
+type ActionFactory func(req ActionRequest) (Action, error)
+
+// actionFactories holds the actions rules can trigger, by name
+var actionFactories = struct {
+	sync.RWMutex
+	m map[string]ActionFactory
+}{m: make(map[string]ActionFactory)}
 
-// Add an action to the map of actions for a channel
-func (a ChannelActions) Add(channelID string, action string) {
-	if a == nil {
-		a = make(ChannelActions)
+// RegisterAction makes an action available to rules under name. Actions usually register
+// themselves in an init function. It panics if name is already registered.
+func RegisterAction(name string, factory ActionFactory) {
+	actionFactories.Lock()
+	defer actionFactories.Unlock()
+	if factory == nil {
+		panic("discord: RegisterAction factory is nil")
+	}
+	if _, ok := actionFactories.m[name]; ok {
+		panic("discord: RegisterAction called twice for action " + name)
 	}
-	if _, ok := a[channelID]; !ok {
-		a[channelID] = []string{}
+	actionFactories.m[name] = factory
+}
+
+// ActionRegistered reports whether an action is registered under name
+func ActionRegistered(name string) bool {
+	actionFactories.RLock()
+	defer actionFactories.RUnlock()
+	_, ok := actionFactories.m[name]
+	return ok
+}
+
+// newAction creates the action registered under name
+func newAction(name string, req ActionRequest) (Action, error) {
+	actionFactories.RLock()
+	factory, ok := actionFactories.m[name]
+	actionFactories.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown action %q", name)
 	}
-	a[channelID] = append(a[channelID], action)
+	return factory(req)
+}
+
+// Built-in actions
+func init() {
+	RegisterAction(ActionReply, func(req ActionRequest) (Action, error) {
+		return &Reply{session: req.Session, event: req.Event, response: req.Params["message"]}, nil
+	})
+	RegisterAction(ActionAddTracksToPlaylist, func(req ActionRequest) (Action, error) {
+		if req.handler == nil {
+			return nil, fmt.Errorf("%s needs the message handler", ActionAddTracksToPlaylist)
+		}
+		return &AddTracksToPlaylist{
+			session:       req.Session,
+			event:         req.Event,
+			playlistAdder: req.handler.playlistAdder,
+			guilds:        req.handler.guilds,
+			confirmations: req.handler.confirmations,
+			playlistID:    req.Params["playlist"],
+		}, nil
+	})
 }
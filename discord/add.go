@@ -0,0 +1,212 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/track"
+	"discordbot/spotify/worker"
+	"discordbot/utils/ctxutil"
+)
+
+const (
+	// searchLimit is the number of suggestions offered while typing; Discord allows at most 25
+	searchLimit = 10
+
+	// minSearchLength is the shortest query worth searching for
+	minSearchLength = 2
+
+	// maxChoiceLength is the longest name or value Discord accepts for a choice
+	maxChoiceLength = 100
+)
+
+// TrackSearcher is an interface for searching the Spotify catalog
+type TrackSearcher interface {
+	SearchTracks(ctx context.Context, userID, query string, limit int) ([]track.Summary, error)
+}
+
+// NewAddCommand creates the /add slash command, which searches Spotify for a track and adds it
+// to the playlist through the same path as links posted in the songs channel
+func NewAddCommand(
+	playlistAdder PlaylistAdder,
+	searcher TrackSearcher,
+	guilds GuildConfigs,
+	confirmations *Confirmations,
+) *Command {
+	cmd := &addCommand{playlistAdder: playlistAdder, searcher: searcher, guilds: guilds, confirmations: confirmations}
+	return &Command{
+		Name:        addCommandName,
+		Description: "Add a song to the playlist",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         addQueryOption,
+				Description:  "Song to search for, or a song, album or playlist link",
+				Required:     true,
+				Autocomplete: true,
+			},
+		},
+		Handler:      cmd.handle,
+		Autocomplete: cmd.autocomplete,
+		Defer:        true,
+	}
+}
+
+// addCommand holds the dependencies of the /add slash command
+type addCommand struct {
+	playlistAdder PlaylistAdder
+	searcher      TrackSearcher
+	guilds        GuildConfigs
+	confirmations *Confirmations
+}
+
+// autocomplete suggests tracks matching what the user has typed so far
+func (c *addCommand) autocomplete(ctx context.Context, _ *discordgo.Session, i *discordgo.InteractionCreate) (*discordgo.InteractionResponse, error) {
+	query := strings.TrimSpace(focusedOptionValue(i))
+	if utf8.RuneCountInString(query) < minSearchLength {
+		return autocompleteResponse(nil), nil
+	}
+	if _, ok := extractSongURLs(query); ok {
+		// Links are submitted as-is
+		return autocompleteResponse(nil), nil
+	}
+
+	results, err := c.searcher.SearchTracks(ctx, interactionUserID(i), query, searchLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search tracks: %w", err)
+	}
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(results))
+	for _, result := range results {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  truncate(result.String(), maxChoiceLength),
+			Value: result.URL(),
+		})
+	}
+	return autocompleteResponse(choices), nil
+}
+
+// handle adds the chosen track to the playlist
+func (c *addCommand) handle(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) (*discordgo.InteractionResponse, error) {
+	userID := interactionUserID(i)
+	query := strings.TrimSpace(optionValue(i.ApplicationCommandData().Options, addQueryOption))
+	ctx, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.Query, query))
+
+	// Autocomplete choices submit the track link; anything else is searched and the best match used
+	trackURLs, ok := extractSongURLs(query)
+	var searchLater string
+	if !ok {
+		results, err := c.searcher.SearchTracks(ctx, userID, query, 1)
+		switch {
+		case errors.Is(err, worker.ErrAuthRequired):
+			// The search is submitted instead, so it is queued and auth triggered like for links
+			searchLater = query
+		case err != nil:
+			return nil, fmt.Errorf("failed to search tracks: %w", err)
+		case len(results) == 0:
+			return messageResponse(fmt.Sprintf("🔍 No tracks found for %q", query)), nil
+		default:
+			trackURLs = []string{results[0].URL()}
+		}
+	}
+
+	playlistID := c.guilds.Guild(i.GuildID).PlaylistForChannel(i.ChannelID)
+	if playlistID == "" {
+		return nil, fmt.Errorf("no playlist configured for channel %s", i.ChannelID)
+	}
+
+	ctx, fields = ctxutil.WithZapFields(
+		ctx,
+		zap.String(zapkey.PlaylistID, playlistID),
+		zap.Strings(zapkey.TrackURLs, trackURLs),
+	)
+	logger.Info("Adding tracks from slash command", fields...)
+
+	sub := track.Submission{
+		UserID:     userID,
+		GuildID:    i.GuildID,
+		ChannelID:  i.ChannelID,
+		PlaylistID: playlistID,
+		TrackURLs:  trackURLs,
+		Query:      searchLater,
+	}
+	results, err := c.playlistAdder.AddTracksToPlaylist(ctx, sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add tracks to playlist: %w", err)
+	}
+
+	if err := c.confirmations.Prompt(ctx, s, sub, results); err != nil {
+		logger.With(zap.Error(err)).Warn("Failed to ask for confirmation", fields...)
+	}
+
+	return resultResponse(userID, sub.Links(), results), nil
+}
+
+// resultResponse describes the outcome of a submission made without a message to react to
+func resultResponse(userID string, trackURLs []string, results []track.Result) *discordgo.InteractionResponse {
+	links := strings.Join(trackURLs, " ")
+	switch track.Summarize(results) {
+	case track.StatusAdded:
+		return messageResponse(fmt.Sprintf("%s Added %s to the playlist", statusEmojis[track.StatusAdded], links))
+	case track.StatusDuplicate:
+		response := messageResponse(duplicatesMessage(results))
+		response.Data.AllowedMentions = &discordgo.MessageAllowedMentions{}
+		return response
+	case track.StatusAuthPending:
+		return messageResponse(fmt.Sprintf(
+			"%s %s will be added once <@%s> connects Spotify", statusEmojis[track.StatusAuthPending], links, userID))
+	case track.StatusRetrying:
+		return messageResponse(fmt.Sprintf(
+			"%s Spotify isn't responding; %s will be added when it recovers", statusEmojis[track.StatusRetrying], links))
+	case track.StatusNeedsConfirmation:
+		if i := slices.IndexFunc(results, func(r track.Result) bool {
+			return r.Status == track.StatusNeedsConfirmation && r.Source != ""
+		}); i >= 0 {
+			return messageResponse(fmt.Sprintf(
+				"%s %s — confirm below to add it", statusEmojis[track.StatusNeedsConfirmation], matchDescription(results[i])))
+		}
+		return messageResponse(fmt.Sprintf(
+			"%s %s adds a lot of tracks — confirm below to add them", statusEmojis[track.StatusNeedsConfirmation], links))
+	default:
+		return messageResponse(fmt.Sprintf("%s Could not add %s to the playlist", statusEmojis[track.StatusFailed], links))
+	}
+}
+
+// --- Option Helpers ---
+
+// optionValue returns the string value of the named option, or "" if it was not provided
+func optionValue(options []*discordgo.ApplicationCommandInteractionDataOption, name string) string {
+	for _, opt := range options {
+		if opt.Name == name && opt.Type == discordgo.ApplicationCommandOptionString {
+			return opt.StringValue()
+		}
+	}
+	return ""
+}
+
+// focusedOptionValue returns the value of the option the user is currently typing in
+func focusedOptionValue(i *discordgo.InteractionCreate) string {
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Focused && opt.Type == discordgo.ApplicationCommandOptionString {
+			return opt.StringValue()
+		}
+	}
+	return ""
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,156 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	spotifyclient "discordbot/spotify"
+	"discordbot/utils/ctxutil"
+)
+
+// authLinkResponseWait bounds how long an auth link waits for the interaction that triggered it
+// to be answered. The link is sent by DM instead if the interaction is not answered in time.
+const authLinkResponseWait = 30 * time.Second
+
+// --- Interaction Context ---
+
+// interactionKeyType is the key type for storing the interaction being handled in the context
+type interactionKeyType struct{}
+
+// interactionKey is the key for storing the interaction being handled in the context
+var interactionKey = interactionKeyType{}
+
+// pendingInteraction is an interaction being handled, which messages meant only for the user
+// that triggered it can be sent as ephemeral follow-ups to
+type pendingInteraction struct {
+	session     *discordgo.Session
+	interaction *discordgo.Interaction
+	userID      string
+
+	once      sync.Once
+	responded chan struct{} // Closed once the final response is sent; a deferral does not count
+
+	mu           sync.Mutex // Serializes the initial response between the router and the handler
+	acknowledged bool       // The initial response was sent
+	deferred     bool       // The initial response was the router's deferral
+	replaced     bool       // The handler replaced the deferral with its answer
+}
+
+// withInteraction injects the interaction being handled into the context
+func withInteraction(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) context.Context {
+	return context.WithValue(ctx, interactionKey, &pendingInteraction{
+		session:     s,
+		interaction: i.Interaction,
+		userID:      interactionUserID(i),
+		responded:   make(chan struct{}),
+	})
+}
+
+// interactionFromContext retrieves the interaction being handled, or nil if there is none
+func interactionFromContext(ctx context.Context) *pendingInteraction {
+	p, _ := ctx.Value(interactionKey).(*pendingInteraction)
+	return p
+}
+
+// markResponded records that the final response to the interaction in the context has been
+// sent. Follow-ups sent before then may replace a deferred response rather than create a new
+// message, which makes them public if the deferral was, so auth links wait for this.
+func markResponded(ctx context.Context) {
+	if p := interactionFromContext(ctx); p != nil {
+		p.once.Do(func() { close(p.responded) })
+	}
+}
+
+// --- Auth Links ---
+
+// SendAuthLink delivers a user's Spotify login link privately: as an ephemeral follow-up if the
+// user triggered the flow with an interaction, and by DM otherwise. The link is never posted in
+// a shared channel, since whoever opens it connects their Spotify account to the user. If the
+// user does not accept DMs, an error wrapping spotify.ErrDMsClosed is returned so the Spotify
+// client can ask them to open them.
+func (c *Client) SendAuthLink(ctx context.Context, userID string, authURL string) error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("failed to validate discord client: %w", err)
+	}
+	_, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.UserID, userID))
+
+	if p := interactionFromContext(ctx); p != nil && p.userID == userID {
+		err := sendEphemeralAuthLink(ctx, p, authURL)
+		if err == nil {
+			logger.Info("Sent Spotify login link as an ephemeral follow-up", fields...)
+			return nil
+		}
+		logger.With(zap.Error(err)).Warn("Failed to send Spotify login link as a follow-up; sending it by DM", fields...)
+	}
+
+	err := c.sendDMAuthLink(userID, authURL)
+	if err == nil {
+		logger.Info("Sent Spotify login link by DM", fields...)
+		return nil
+	}
+	if dmsClosed(err) {
+		return fmt.Errorf("failed to DM login link: %w: %w", spotifyclient.ErrDMsClosed, err)
+	}
+	return fmt.Errorf("failed to DM login link: %w", err)
+}
+
+// sendEphemeralAuthLink sends the login link as a follow-up to the interaction only its user
+// can see, once the final response to the interaction has been sent so the follow-up is a new
+// message rather than an edit of the response
+func sendEphemeralAuthLink(ctx context.Context, p *pendingInteraction, authURL string) error {
+	select {
+	case <-p.responded:
+	case <-time.After(authLinkResponseWait):
+		return errors.New("interaction was not answered in time")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	_, err := p.session.FollowupMessageCreate(p.interaction, true, &discordgo.WebhookParams{
+		Content:    "🎵 Connect your Spotify account to add tracks. Only you can see this link.",
+		Components: authLinkComponents(authURL),
+		Flags:      discordgo.MessageFlagsEphemeral,
+	})
+	return err
+}
+
+// sendDMAuthLink sends the login link to the user by DM
+func (c *Client) sendDMAuthLink(userID string, authURL string) error {
+	dm, err := c.session.UserChannelCreate(userID)
+	if err != nil {
+		return err
+	}
+	_, err = c.session.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{
+		Content:    "🎵 Connect your Spotify account to add the tracks you post. Don't share this link.",
+		Components: authLinkComponents(authURL),
+	})
+	return err
+}
+
+// authLinkComponents creates the "Connect Spotify" button opening the login link
+func authLinkComponents(authURL string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Style: discordgo.LinkButton, Label: "Connect Spotify", URL: authURL},
+		}},
+	}
+}
+
+// dmsClosed reports whether err means the user does not accept DMs from the bot
+func dmsClosed(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) {
+		return false
+	}
+	if restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeCannotSendMessagesToThisUser {
+		return true
+	}
+	return restErr.Response != nil && restErr.Response.StatusCode == http.StatusForbidden
+}
@@ -9,6 +9,10 @@ import (
 	"discordbot/discord/config"
 )
 
+// stateMessageCount is the number of messages per channel kept in the session state so that
+// edit events include the message as it was before the edit
+const stateMessageCount = 500
+
 // -- Client --
 
 type Handler interface {
@@ -21,6 +25,9 @@ type Client struct {
 	session  *discordgo.Session
 	config   *config.Config
 	handlers []Handler
+	commands *Commands
+
+	confirmations *Confirmations // Prompts for links that complete asynchronously
 }
 
 // NewClient creates a new discord client
@@ -71,6 +78,7 @@ func (c *Client) createSession() error {
 	if err != nil {
 		return fmt.Errorf("failed to create discord session: %w", err)
 	}
+	session.State.MaxMessageCount = stateMessageCount
 	c.session = session
 
 	return nil
@@ -134,6 +142,12 @@ func (c *Client) Start() error {
 	if err != nil {
 		return fmt.Errorf("failed to open discord session: %w", err)
 	}
+
+	// Register slash commands with Discord. A failure here leaves previously registered
+	// commands in place, so log it rather than refusing to start.
+	if err := c.syncCommands(); err != nil {
+		logger.Error("failed to sync slash commands", zap.Error(err))
+	}
 	return nil
 }
 
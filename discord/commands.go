@@ -0,0 +1,141 @@
+package discord
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Command declares a slash command and the handlers that respond to it
+type Command struct {
+	Name        string
+	Description string
+	Options     []*discordgo.ApplicationCommandOption
+
+	DefaultMemberPermissions *int64                             // Permissions needed to use the command by default; nil lets everyone
+	NSFW                     bool                               // Only usable in age-restricted channels
+	Contexts                 []discordgo.InteractionContextType // Where the command can be used; defaults to guilds and DMs with the bot
+
+	Handler      InteractionHandler // Responds to the command
+	Autocomplete InteractionHandler // Suggests values for options with Autocomplete set (optional)
+	Defer        bool               // Defer the response up front; see Route.Defer
+	Ephemeral    bool               // Only show responses to the invoking user; see Route.Ephemeral
+}
+
+// defaultContexts are where commands can be used unless they say otherwise
+var defaultContexts = []discordgo.InteractionContextType{
+	discordgo.InteractionContextGuild,
+	discordgo.InteractionContextBotDM,
+}
+
+// ApplicationCommand returns the Discord representation of the command. Every field Discord
+// registers is set explicitly, so the registered command can be compared with it.
+func (c *Command) ApplicationCommand() *discordgo.ApplicationCommand {
+	contexts := c.Contexts
+	if contexts == nil {
+		contexts = defaultContexts
+	}
+	nsfw := c.NSFW
+	integrationTypes := []discordgo.ApplicationIntegrationType{discordgo.ApplicationIntegrationGuildInstall}
+	return &discordgo.ApplicationCommand{
+		Type:                     discordgo.ChatApplicationCommand,
+		Name:                     c.Name,
+		Description:              c.Description,
+		Options:                  c.Options,
+		DefaultMemberPermissions: c.DefaultMemberPermissions,
+		NSFW:                     &nsfw,
+		Contexts:                 &contexts,
+		IntegrationTypes:         &integrationTypes,
+	}
+}
+
+// Validate checks that the command can be registered with Discord
+func (c *Command) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("command name is empty")
+	}
+	if c.Description == "" {
+		return fmt.Errorf("command %q has no description", c.Name)
+	}
+	if c.Handler == nil {
+		return fmt.Errorf("command %q has no handler", c.Name)
+	}
+	return nil
+}
+
+// Commands is the registry of slash commands served by the bot, keyed by name
+type Commands struct {
+	commands map[string]*Command
+}
+
+// NewCommands creates a new command registry containing the given commands
+func NewCommands(commands ...*Command) (*Commands, error) {
+	r := &Commands{commands: make(map[string]*Command)}
+	for _, cmd := range commands {
+		if err := r.Register(cmd); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds a command to the registry
+func (r *Commands) Register(cmd *Command) error {
+	if cmd == nil {
+		return fmt.Errorf("command is nil")
+	}
+	if err := cmd.Validate(); err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+	if _, ok := r.commands[cmd.Name]; ok {
+		return fmt.Errorf("command %q is already registered", cmd.Name)
+	}
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+// Get returns the command registered under name
+func (r *Commands) Get(name string) (*Command, bool) {
+	if r == nil {
+		return nil, false
+	}
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// List returns all registered commands sorted by name
+func (r *Commands) List() []*Command {
+	if r == nil {
+		return nil
+	}
+	list := make([]*Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		list = append(list, cmd)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// BuiltinCommands returns the slash commands the bot always serves
+func BuiltinCommands() []*Command {
+	return []*Command{
+		{
+			Name:        testCommand,
+			Description: "Check that the bot is responding",
+			Handler:     handleTestCommand,
+		},
+		{
+			Name:        challengeCommand,
+			Description: "Challenge the bot",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        challengeChoiceOption,
+					Description: "What you challenge the bot with",
+				},
+			},
+			Handler: handleChallengeCommand,
+		},
+	}
+}
@@ -0,0 +1,165 @@
+package discord
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+)
+
+// syncCommands converges the slash commands registered with Discord to the declared set.
+// Commands are registered to the dev guild when one is configured, otherwise globally. With a
+// dev guild, global commands are left alone, since the app may also serve production, unless
+// cleaning them up is enabled so they do not show up twice in the dev guild.
+func (c *Client) syncCommands() error {
+	if c.commands == nil {
+		return nil
+	}
+	guildID := c.config.DevGuildID
+	var declared []*discordgo.ApplicationCommand
+	for _, cmd := range c.commands.List() {
+		command := cmd.ApplicationCommand()
+		if guildID != "" {
+			// Only global commands have contexts and integration types
+			command.Contexts = nil
+			command.IntegrationTypes = nil
+		}
+		declared = append(declared, command)
+	}
+
+	if err := c.syncScope(guildID, declared); err != nil {
+		return err
+	}
+	if guildID != "" && c.config.CleanGlobalCommands {
+		if err := c.syncScope("", nil); err != nil {
+			return fmt.Errorf("failed to clean up global commands: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncScope converges the commands registered in a guild, or globally if guildID is empty, to
+// the declared commands
+func (c *Client) syncScope(guildID string, declared []*discordgo.ApplicationCommand) error {
+	appID := c.config.AppID
+	fields := []zap.Field{zap.String(zapkey.AppID, appID), zap.String(zapkey.GuildID, guildID)}
+
+	existing, err := c.session.ApplicationCommands(appID, guildID)
+	if err != nil {
+		return fmt.Errorf("failed to list registered commands: %w", err)
+	}
+	registered := make(map[string]*discordgo.ApplicationCommand, len(existing))
+	for _, cmd := range existing {
+		registered[cmd.Name] = cmd
+	}
+
+	// Create or update declared commands
+	for _, cmd := range declared {
+		current, ok := registered[cmd.Name]
+		delete(registered, cmd.Name)
+
+		switch {
+		case !ok:
+			if _, err := c.session.ApplicationCommandCreate(appID, guildID, cmd); err != nil {
+				return fmt.Errorf("failed to create command %q: %w", cmd.Name, err)
+			}
+			logger.Info("Created slash command", append(fields, zap.String(zapkey.Command, cmd.Name))...)
+		case !sameCommand(current, cmd):
+			if _, err := c.session.ApplicationCommandEdit(appID, guildID, current.ID, cmd); err != nil {
+				return fmt.Errorf("failed to update command %q: %w", cmd.Name, err)
+			}
+			logger.Info("Updated slash command", append(fields, zap.String(zapkey.Command, cmd.Name))...)
+		}
+	}
+
+	// Delete commands that are no longer declared
+	for name, cmd := range registered {
+		if err := c.session.ApplicationCommandDelete(appID, guildID, cmd.ID); err != nil {
+			return fmt.Errorf("failed to delete command %q: %w", name, err)
+		}
+		logger.Info("Deleted slash command", append(fields, zap.String(zapkey.Command, name))...)
+	}
+	return nil
+}
+
+// sameCommand reports whether a registered command matches the declared one in every field
+// that can be registered. Contexts and integration types are only compared if declared, since
+// guild commands have none. The deprecated DM permission follows from the contexts.
+func sameCommand(registered, declared *discordgo.ApplicationCommand) bool {
+	return commandType(registered) == commandType(declared) &&
+		registered.Name == declared.Name &&
+		registered.Description == declared.Description &&
+		maps.Equal(deref(registered.NameLocalizations), deref(declared.NameLocalizations)) &&
+		maps.Equal(deref(registered.DescriptionLocalizations), deref(declared.DescriptionLocalizations)) &&
+		// nil lets everyone use the command, unlike 0, which lets only administrators
+		samePtr(registered.DefaultMemberPermissions, declared.DefaultMemberPermissions) &&
+		deref(registered.NSFW) == deref(declared.NSFW) &&
+		(declared.Contexts == nil || slices.Equal(deref(registered.Contexts), *declared.Contexts)) &&
+		(declared.IntegrationTypes == nil || slices.Equal(deref(registered.IntegrationTypes), *declared.IntegrationTypes)) &&
+		sameOptions(registered.Options, declared.Options)
+}
+
+// commandType returns the type of a command; Discord treats an unset type as a chat command
+func commandType(cmd *discordgo.ApplicationCommand) discordgo.ApplicationCommandType {
+	if cmd.Type == 0 {
+		return discordgo.ChatApplicationCommand
+	}
+	return cmd.Type
+}
+
+// samePtr reports whether a and b are both nil or point to equal values
+func samePtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// deref returns the value p points to, or the zero value if p is nil
+func deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
+
+// sameOptions reports whether two option lists are equivalent, ignoring fields Discord fills in
+func sameOptions(a, b []*discordgo.ApplicationCommandOption) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if x.Type != y.Type ||
+			x.Name != y.Name ||
+			x.Description != y.Description ||
+			!maps.Equal(x.NameLocalizations, y.NameLocalizations) ||
+			!maps.Equal(x.DescriptionLocalizations, y.DescriptionLocalizations) ||
+			!slices.Equal(x.ChannelTypes, y.ChannelTypes) ||
+			x.Required != y.Required ||
+			x.Autocomplete != y.Autocomplete ||
+			!samePtr(x.MinValue, y.MinValue) ||
+			x.MaxValue != y.MaxValue ||
+			!samePtr(x.MinLength, y.MinLength) ||
+			x.MaxLength != y.MaxLength ||
+			len(x.Choices) != len(y.Choices) {
+			return false
+		}
+		for j := range x.Choices {
+			if x.Choices[j].Name != y.Choices[j].Name ||
+				!maps.Equal(x.Choices[j].NameLocalizations, y.Choices[j].NameLocalizations) ||
+				fmt.Sprint(x.Choices[j].Value) != fmt.Sprint(y.Choices[j].Value) {
+				return false
+			}
+		}
+		if !sameOptions(x.Options, y.Options) {
+			return false
+		}
+	}
+	return true
+}
@@ -3,7 +3,12 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"discordbot/constants/envvar"
 	"discordbot/discord/channel"
@@ -12,19 +17,70 @@ import (
 // Config represents the configuration for the Discord client
 type Config struct {
 	Token      string
-	ChannelIDs map[channel.Type]string
+	AppID      string
+	DevGuildID string // Guild to register slash commands in; global when empty
+
+	// CleanGlobalCommands deletes the app's global slash commands when registering them in the
+	// dev guild. Leave it off if the dev guild's app also serves production.
+	CleanGlobalCommands bool
+
+	// GuildConfig is the configuration of guilds without their own entry in Guilds. It is read
+	// from environment variables, so single-guild deployments need no guilds file.
+	GuildConfig
+
+	// Per-guild configuration keyed by guild ID, loaded from the guilds file
+	Guilds map[string]*GuildConfig
 }
 
 // NewConfig creates a new configuration struct for the Discord client
 func NewConfig(opts ...Option) (*Config, error) {
 	c := &Config{
-		Token: os.Getenv(envvar.DiscordToken),
-		ChannelIDs: map[channel.Type]string{
-			channel.Auth:  os.Getenv(envvar.DiscordAuthChannelID),
-			channel.Debug: os.Getenv(envvar.DiscordDebugChannelID),
-			channel.Songs: os.Getenv(envvar.DiscordSongsChannelID),
+		Token:      os.Getenv(envvar.DiscordToken),
+		AppID:      os.Getenv(envvar.DiscordAppID),
+		DevGuildID: os.Getenv(envvar.DiscordDevGuildID),
+		GuildConfig: GuildConfig{
+			ChannelIDs: map[channel.Type]string{
+				channel.Auth:  os.Getenv(envvar.DiscordAuthChannelID),
+				channel.Debug: os.Getenv(envvar.DiscordDebugChannelID),
+				channel.Songs: os.Getenv(envvar.DiscordSongsChannelID),
+			},
+			DefaultPlaylistID: os.Getenv(envvar.SpotifyPlaylistID),
 		},
 	}
+	playlists, err := ParseMapping(os.Getenv(envvar.DiscordChannelPlaylists))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envvar.DiscordChannelPlaylists, err)
+	}
+	c.ChannelPlaylists = playlists
+	tags, err := ParseMapping(os.Getenv(envvar.DiscordTagPlaylists))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envvar.DiscordTagPlaylists, err)
+	}
+	c.TagPlaylists = make(map[string]string, len(tags))
+	for tag, playlistID := range tags {
+		c.TagPlaylists[NormalizeTag(tag)] = playlistID
+	}
+	if grace := os.Getenv(envvar.DiscordDeleteGracePeriod); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envvar.DiscordDeleteGracePeriod, err)
+		}
+		c.DeleteGracePeriod = d
+	}
+	if clean := os.Getenv(envvar.DiscordCleanGlobalCommands); clean != "" {
+		b, err := strconv.ParseBool(clean)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envvar.DiscordCleanGlobalCommands, err)
+		}
+		c.CleanGlobalCommands = b
+	}
+	if path := os.Getenv(envvar.DiscordGuildsFile); path != "" {
+		guilds, err := LoadGuilds(path, c.GuildConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load guilds file: %w", err)
+		}
+		c.Guilds = guilds
+	}
 	for _, opt := range opts {
 		opt(c)
 	}
@@ -40,21 +96,79 @@ func (c *Config) Validate() error {
 	if c.Token == "" {
 		return fmt.Errorf("discord token is not set")
 	}
-
-	// Optional fields
-	if c.ChannelIDs == nil {
-		c.ChannelIDs = make(map[channel.Type]string)
+	if c.AppID == "" {
+		return fmt.Errorf("discord app ID is not set")
 	}
 
-	// Required channel IDs - add to this list to require additional channels at startup
-	for _, channelType := range []channel.Type{channel.Auth, channel.Songs} {
-		if c.ChannelIDs[channelType] == "" {
-			return fmt.Errorf("%s channel ID is not set", channelType)
+	// The default guild configuration only needs to be complete when no guilds are configured
+	if err := c.GuildConfig.validate(len(c.Guilds) == 0); err != nil {
+		return err
+	}
+	for guildID, guild := range c.Guilds {
+		if guild == nil {
+			return fmt.Errorf("guild %s: configuration is empty", guildID)
+		}
+		if err := guild.validate(true); err != nil {
+			return fmt.Errorf("guild %s: %w", guildID, err)
 		}
 	}
 	return nil
 }
 
+// Guild returns the configuration of a guild, falling back to the default configuration
+func (c *Config) Guild(guildID string) *GuildConfig {
+	if guild, ok := c.Guilds[guildID]; ok {
+		return guild
+	}
+	return &c.GuildConfig
+}
+
+// AllGuilds returns the configuration of every configured guild, and the default configuration
+// if it is in use (i.e. when no guilds are configured)
+func (c *Config) AllGuilds() []*GuildConfig {
+	if len(c.Guilds) == 0 {
+		return []*GuildConfig{&c.GuildConfig}
+	}
+	guilds := make([]*GuildConfig, 0, len(c.Guilds))
+	for _, guildID := range slices.Sorted(maps.Keys(c.Guilds)) {
+		guilds = append(guilds, c.Guilds[guildID])
+	}
+	return guilds
+}
+
+// PlaylistIDs returns every playlist submissions can be added to in any guild
+func (c *Config) PlaylistIDs() []string {
+	seen := make(map[string]struct{})
+	var ids []string
+	for _, guild := range c.AllGuilds() {
+		for _, id := range guild.PlaylistIDs() {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ParseMapping parses a comma-separated list of key=value pairs, e.g. "a=1,b=2"
+func ParseMapping(val string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		mapping[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return mapping, nil
+}
+
 // Option is a function that overrides a default configuration value
 type Option func(*Config)
 
@@ -74,3 +188,51 @@ func WithToken(token string) Option {
 		c.Token = token
 	}
 }
+
+// WithAppID sets the discord application ID
+func WithAppID(appID string) Option {
+	return func(c *Config) {
+		c.AppID = appID
+	}
+}
+
+// WithDevGuildID sets the guild slash commands are registered in
+func WithDevGuildID(guildID string) Option {
+	return func(c *Config) {
+		c.DevGuildID = guildID
+	}
+}
+
+// WithDeleteGracePeriod sets how long after a track is added that deleting its message removes it
+func WithDeleteGracePeriod(d time.Duration) Option {
+	return func(c *Config) {
+		c.DeleteGracePeriod = d
+	}
+}
+
+// WithChannelPlaylist routes submissions in a channel to a playlist
+func WithChannelPlaylist(channelID, playlistID string) Option {
+	return func(c *Config) {
+		if c.ChannelPlaylists == nil {
+			c.ChannelPlaylists = make(map[string]string)
+		}
+		c.ChannelPlaylists[channelID] = playlistID
+	}
+}
+
+// WithDefaultPlaylistID sets the playlist for channels without their own
+func WithDefaultPlaylistID(playlistID string) Option {
+	return func(c *Config) {
+		c.DefaultPlaylistID = playlistID
+	}
+}
+
+// WithTagPlaylist also adds submissions tagged with #tag to a playlist
+func WithTagPlaylist(tag, playlistID string) Option {
+	return func(c *Config) {
+		if c.TagPlaylists == nil {
+			c.TagPlaylists = make(map[string]string)
+		}
+		c.TagPlaylists[NormalizeTag(tag)] = playlistID
+	}
+}
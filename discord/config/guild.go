@@ -0,0 +1,176 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"discordbot/discord/channel"
+)
+
+// GuildConfig is the configuration of the bot in one guild
+type GuildConfig struct {
+	ChannelIDs map[channel.Type]string
+
+	// How long after a track is added that deleting its message removes it from the playlist.
+	// Zero disables removal on delete.
+	DeleteGracePeriod time.Duration
+
+	// Playlist that each channel's submissions are added to, keyed by channel ID.
+	// Channels without an entry use DefaultPlaylistID.
+	ChannelPlaylists  map[string]string
+	DefaultPlaylistID string
+
+	// Secondary playlists that submissions tagged with a hashtag are also added to, keyed by
+	// lower-case tag without the #
+	TagPlaylists map[string]string
+}
+
+// validate checks that the guild configuration is valid. If complete is set, the channels
+// and playlist every guild needs must also be set.
+func (g *GuildConfig) validate(complete bool) error {
+	if g.DeleteGracePeriod < 0 {
+		return fmt.Errorf("delete grace period must not be negative")
+	}
+
+	// Optional fields
+	if g.ChannelIDs == nil {
+		g.ChannelIDs = make(map[channel.Type]string)
+	}
+	if g.ChannelPlaylists == nil {
+		g.ChannelPlaylists = make(map[string]string)
+	}
+	for channelID, playlistID := range g.ChannelPlaylists {
+		if channelID == "" || playlistID == "" {
+			return fmt.Errorf("channel playlist mapping %q=%q is incomplete", channelID, playlistID)
+		}
+	}
+	if g.TagPlaylists == nil {
+		g.TagPlaylists = make(map[string]string)
+	}
+	for tag, playlistID := range g.TagPlaylists {
+		if tag == "" || playlistID == "" {
+			return fmt.Errorf("tag playlist mapping %q=%q is incomplete", tag, playlistID)
+		}
+	}
+	if !complete {
+		return nil
+	}
+
+	// Required channel IDs - add to this list to require additional channels at startup
+	for _, channelType := range []channel.Type{channel.Auth, channel.Songs} {
+		if g.ChannelIDs[channelType] == "" {
+			return fmt.Errorf("%s channel ID is not set", channelType)
+		}
+	}
+	if g.DefaultPlaylistID == "" && len(g.ChannelPlaylists) == 0 {
+		return fmt.Errorf("no playlist is set")
+	}
+	return nil
+}
+
+// PlaylistForChannel returns the ID of the playlist that submissions in the channel are added to
+func (g *GuildConfig) PlaylistForChannel(channelID string) string {
+	if playlistID, ok := g.ChannelPlaylists[channelID]; ok {
+		return playlistID
+	}
+	return g.DefaultPlaylistID
+}
+
+// PlaylistForTag returns the ID of the playlist that submissions tagged with tag are also added to
+func (g *GuildConfig) PlaylistForTag(tag string) (string, bool) {
+	playlistID, ok := g.TagPlaylists[NormalizeTag(tag)]
+	return playlistID, ok
+}
+
+// NormalizeTag returns tag in the form used as a key of TagPlaylists
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// PlaylistIDs returns every playlist submissions in the guild can be added to, including the default
+func (g *GuildConfig) PlaylistIDs() []string {
+	seen := make(map[string]struct{})
+	var ids []string
+	candidates := append([]string{g.DefaultPlaylistID}, slices.Sorted(maps.Values(g.ChannelPlaylists))...)
+	candidates = append(candidates, slices.Sorted(maps.Values(g.TagPlaylists))...)
+	for _, id := range candidates {
+		if _, ok := seen[id]; ok || id == "" {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// --- Guilds File ---
+
+// guildsFile is the format of the guilds file, e.g.
+//
+//	{
+//	  "guilds": {
+//	    "<guild ID>": {
+//	      "channels": {"Songs": "<channel ID>", "Authentication": "<channel ID>", "Debug": "<channel ID>"},
+//	      "playlist_id": "<default playlist ID>",
+//	      "channel_playlists": {"<channel ID>": "<playlist ID>"},
+//	      "tag_playlists": {"gym": "<playlist ID>"},
+//	      "delete_grace_period": "10m"
+//	    }
+//	  }
+//	}
+type guildsFile struct {
+	Guilds map[string]guildEntry `json:"guilds"`
+}
+
+// guildEntry is the configuration of one guild in the guilds file
+type guildEntry struct {
+	Channels          map[channel.Type]string `json:"channels"`
+	PlaylistID        string                  `json:"playlist_id"`
+	ChannelPlaylists  map[string]string       `json:"channel_playlists"`
+	TagPlaylists      map[string]string       `json:"tag_playlists"`
+	DeleteGracePeriod *string                 `json:"delete_grace_period"`
+}
+
+// LoadGuilds reads per-guild configuration from a JSON file. Settings a guild leaves out
+// (playlist and delete grace period) are taken from defaults.
+func LoadGuilds(path string, defaults GuildConfig) (map[string]*GuildConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var file guildsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	guilds := make(map[string]*GuildConfig, len(file.Guilds))
+	for guildID, entry := range file.Guilds {
+		guild := &GuildConfig{
+			ChannelIDs:        entry.Channels,
+			DeleteGracePeriod: defaults.DeleteGracePeriod,
+			ChannelPlaylists:  entry.ChannelPlaylists,
+			DefaultPlaylistID: entry.PlaylistID,
+			TagPlaylists:      make(map[string]string, len(entry.TagPlaylists)),
+		}
+		if guild.DefaultPlaylistID == "" {
+			guild.DefaultPlaylistID = defaults.DefaultPlaylistID
+		}
+		for tag, playlistID := range entry.TagPlaylists {
+			guild.TagPlaylists[NormalizeTag(tag)] = playlistID
+		}
+		if entry.DeleteGracePeriod != nil {
+			d, err := time.ParseDuration(*entry.DeleteGracePeriod)
+			if err != nil {
+				return nil, fmt.Errorf("guild %s: invalid delete grace period: %w", guildID, err)
+			}
+			guild.DeleteGracePeriod = d
+		}
+		guilds[guildID] = guild
+	}
+	return guilds, nil
+}
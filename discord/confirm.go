@@ -0,0 +1,282 @@
+package discord
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/track"
+	"discordbot/utils/ctxutil"
+)
+
+const (
+	// confirmPrefix and cancelPrefix route the buttons on confirmation prompts
+	confirmPrefix = "confirm-add"
+	cancelPrefix  = "cancel-add"
+
+	// confirmationTTL is how long a confirmation prompt can be answered
+	confirmationTTL = 30 * time.Minute
+)
+
+// pendingConfirmation is a link waiting for its submitter to confirm it
+type pendingConfirmation struct {
+	sub     track.Submission // Submission of just the link being confirmed
+	count   int              // Number of tracks the link would add
+	source  bool             // The link was converted from another platform
+	expires time.Time
+}
+
+// Confirmations asks submitters to confirm album and playlist links that would add many tracks
+// and uncertain matches for songs linked on other platforms, and adds the tracks once they do
+type Confirmations struct {
+	playlistAdder PlaylistAdder
+
+	mu      sync.Mutex
+	pending map[string]pendingConfirmation // Keyed by token
+}
+
+// NewConfirmations creates a new confirmation store that adds confirmed links with playlistAdder
+func NewConfirmations(playlistAdder PlaylistAdder) *Confirmations {
+	return &Confirmations{
+		playlistAdder: playlistAdder,
+		pending:       make(map[string]pendingConfirmation),
+	}
+}
+
+// Register routes the confirmation buttons to the store
+func (c *Confirmations) Register(router *Router) {
+	router.HandleComponent(confirmPrefix, Route{Handler: c.confirm, Defer: true})
+	router.HandleComponent(cancelPrefix, Route{Handler: c.cancel})
+}
+
+// Prompt posts a confirmation prompt for each result of sub that needs confirmation.
+// Prompts reply to the submitted message when there is one.
+func (c *Confirmations) Prompt(ctx context.Context, s *discordgo.Session, sub track.Submission, results []track.Result) error {
+	if c == nil {
+		return nil
+	}
+	fields := ctxutil.ZapFields(ctx)
+	for _, result := range results {
+		if result.Status != track.StatusNeedsConfirmation {
+			continue
+		}
+		linkSub := sub
+		linkSub.TrackURLs = []string{result.Link}
+		linkSub.Confirmed = true
+		token, err := c.add(linkSub, result.Count, result.Source != "")
+		if err != nil {
+			return err
+		}
+
+		message := &discordgo.MessageSend{
+			Content:         confirmationQuestion(sub.UserID, result),
+			Components:      confirmationButtons(token, result),
+			AllowedMentions: &discordgo.MessageAllowedMentions{Users: []string{sub.UserID}},
+			Flags:           discordgo.MessageFlagsSuppressEmbeds,
+		}
+		if sub.MessageID != "" {
+			message.Reference = &discordgo.MessageReference{ChannelID: sub.ChannelID, MessageID: sub.MessageID}
+		}
+		if _, err := s.ChannelMessageSendComplex(sub.ChannelID, message); err != nil {
+			c.take(token)
+			return fmt.Errorf("failed to send confirmation prompt: %w", err)
+		}
+		logger.With(zap.String(zapkey.URL, result.Link), zap.Int(zapkey.Count, result.Count)).Info("Asked for confirmation", fields...)
+	}
+	return nil
+}
+
+// add stores a pending confirmation and returns its token, dropping expired ones
+func (c *Confirmations) add(sub track.Submission, count int, source bool) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate confirmation token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for t, p := range c.pending {
+		if now.After(p.expires) {
+			delete(c.pending, t)
+		}
+	}
+	c.pending[token] = pendingConfirmation{sub: sub, count: count, source: source, expires: now.Add(confirmationTTL)}
+	return token, nil
+}
+
+// get returns the pending confirmation for token if it has not expired
+func (c *Confirmations) get(token string) (pendingConfirmation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[token]
+	if !ok || time.Now().After(p.expires) {
+		return pendingConfirmation{}, false
+	}
+	return p, true
+}
+
+// take removes and returns the pending confirmation for token if it has not expired
+func (c *Confirmations) take(token string) (pendingConfirmation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[token]
+	delete(c.pending, token)
+	if !ok || time.Now().After(p.expires) {
+		return pendingConfirmation{}, false
+	}
+	return p, true
+}
+
+// confirm adds the tracks of a confirmed link
+func (c *Confirmations) confirm(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) (*discordgo.InteractionResponse, error) {
+	p, response := c.answer(i)
+	if response != nil {
+		return response, nil
+	}
+	sub := p.sub
+	link := sub.TrackURLs[0]
+	ctx, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.URL, link), zap.String(zapkey.UserID, sub.UserID))
+	logger.Info("Submitter confirmed link", fields...)
+
+	results, err := c.playlistAdder.AddTracksToPlaylist(ctx, sub)
+	if err != nil {
+		logger.With(zap.Error(err)).Error("Failed to add confirmed tracks to playlist", fields...)
+	}
+	results = claimOwnDuplicates(results, sub.MessageID)
+
+	status := track.Summarize(results)
+	if sub.MessageID != "" {
+		if err := setStatusReaction(ctx, s, sub.ChannelID, sub.MessageID, status); err != nil {
+			logger.With(zap.Error(err)).Warn("Failed to react with submission status", fields...)
+		}
+	}
+
+	var content string
+	switch status {
+	case track.StatusAdded:
+		content = fmt.Sprintf("%s Added %d tracks from %s", statusEmojis[track.StatusAdded], countStatus(results, track.StatusAdded), link)
+		if p.source {
+			content = fmt.Sprintf("%s Added %s", statusEmojis[track.StatusAdded], link)
+		}
+		if duplicates := countStatus(results, track.StatusDuplicate); duplicates > 0 {
+			content += fmt.Sprintf(" (%d were already in the playlist)", duplicates)
+		}
+	case track.StatusDuplicate:
+		content = duplicatesMessage(results)
+	case track.StatusAuthPending:
+		content = fmt.Sprintf("%s %s will be added once <@%s> connects Spotify", statusEmojis[track.StatusAuthPending], link, sub.UserID)
+	case track.StatusRetrying:
+		content = fmt.Sprintf("%s Spotify isn't responding; %s will be added when it recovers", statusEmojis[track.StatusRetrying], link)
+	default:
+		content = fmt.Sprintf("%s Could not add %s to the playlist", statusEmojis[track.StatusFailed], link)
+	}
+	return promptUpdate(content), nil
+}
+
+// cancel discards a pending link
+func (c *Confirmations) cancel(ctx context.Context, _ *discordgo.Session, i *discordgo.InteractionCreate) (*discordgo.InteractionResponse, error) {
+	p, response := c.answer(i)
+	if response != nil {
+		return response, nil
+	}
+	link := p.sub.TrackURLs[0]
+	_, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.URL, link), zap.String(zapkey.UserID, p.sub.UserID))
+	logger.Info("Submitter cancelled link", fields...)
+	return promptUpdate(fmt.Sprintf("Skipped %s", link)), nil
+}
+
+// answer claims the pending confirmation a button refers to. If it cannot be claimed, the
+// response to send instead is returned.
+func (c *Confirmations) answer(i *discordgo.InteractionCreate) (pendingConfirmation, *discordgo.InteractionResponse) {
+	payload := CustomIDPayload(i)
+	if len(payload) != 1 {
+		return pendingConfirmation{}, promptUpdate("This confirmation is no longer valid.")
+	}
+	token := payload[0]
+
+	// Only the submitter may answer; anyone else is told so privately
+	p, ok := c.get(token)
+	if ok && p.sub.UserID != interactionUserID(i) {
+		return pendingConfirmation{}, ephemeralResponse(fmt.Sprintf("Only <@%s> can confirm this.", p.sub.UserID))
+	}
+	if p, ok = c.take(token); !ok {
+		return pendingConfirmation{}, promptUpdate("This confirmation has expired. Post the link again to add it.")
+	}
+	return p, nil
+}
+
+// confirmationQuestion asks the submitter to confirm a result
+func confirmationQuestion(userID string, result track.Result) string {
+	emoji := statusEmojis[track.StatusNeedsConfirmation]
+	if result.Source != "" {
+		// Shown without an embed, so the question reads well next to the poster's own link
+		return fmt.Sprintf("%s <@%s> %s. Add it?", emoji, userID, matchDescription(result))
+	}
+	return fmt.Sprintf("%s <@%s> %s would add %d tracks to the playlist. Add them all?",
+		emoji, userID, result.Link, result.Count)
+}
+
+// matchDescription describes the song a converted link was matched to on Spotify, where the link
+// came from and how sure the match is, so the submitter can judge it
+func matchDescription(result track.Result) string {
+	source := result.Source
+	if result.SourceSong != "" {
+		source = fmt.Sprintf("**%s** (%s)", result.SourceSong, result.Source)
+	}
+	if result.SourcePlatform != "" {
+		source = fmt.Sprintf("%s on %s", source, result.SourcePlatform)
+	}
+	match := result.Link
+	if result.Match != "" {
+		match = fmt.Sprintf("**%s** (%s)", result.Match, result.Link)
+	}
+	return fmt.Sprintf("The closest Spotify match for %s is %s, a %.0f%% match", source, match, result.Confidence*100)
+}
+
+// confirmationButtons creates the buttons of a confirmation prompt
+func confirmationButtons(token string, result track.Result) []discordgo.MessageComponent {
+	label := "Add all"
+	if result.Source != "" {
+		label = "Add it"
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: label, Style: discordgo.SuccessButton, CustomID: CustomID(confirmPrefix, token)},
+				discordgo.Button{Label: "Cancel", Style: discordgo.SecondaryButton, CustomID: CustomID(cancelPrefix, token)},
+			},
+		},
+	}
+}
+
+// promptUpdate creates a response that replaces a confirmation prompt, removing its buttons
+func promptUpdate(content string) *discordgo.InteractionResponse {
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			Components:      []discordgo.MessageComponent{},
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	}
+}
+
+// countStatus returns the number of results with the given status
+func countStatus(results []track.Result, status track.Status) int {
+	n := 0
+	for _, result := range results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
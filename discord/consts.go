@@ -4,4 +4,20 @@ package discord
 const (
 	testCommand      = "test"
 	challengeCommand = "challenge"
+	addCommandName   = "add"
+
+	spotifyCommandName = "spotify"
+)
+
+// Slash subcommand names
+const (
+	spotifyConnectSubcommand    = "connect"
+	spotifyStatusSubcommand     = "status"
+	spotifyDisconnectSubcommand = "disconnect"
+)
+
+// Slash command option names
+const (
+	challengeChoiceOption = "choice"
+	addQueryOption        = "query"
 )
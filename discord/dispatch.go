@@ -0,0 +1,87 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/utils/ctxutil"
+	"discordbot/utils/workpool"
+)
+
+// busyMessage is the reply to a message whose actions could not be queued
+const busyMessage = "⏳ I'm handling a lot of songs right now. Post the link again in a minute."
+
+// Dispatcher runs the actions for messages on a worker pool so that slow actions do not hold up
+// message handling. The actions for one user's messages run in the order they were posted.
+// Additions to a playlist are not ordered here: the Spotify client batches them and writes each
+// playlist's batches one at a time, in the order they arrive.
+type Dispatcher struct {
+	pool    *workpool.Pool
+	timeout time.Duration // Longest each action may run; 0 for no limit
+}
+
+// NewDispatcher creates a dispatcher running actions on pool, each for at most timeout
+func NewDispatcher(pool *workpool.Pool, timeout time.Duration) *Dispatcher {
+	return &Dispatcher{pool: pool, timeout: timeout}
+}
+
+// Dispatch queues the actions for m, to be run in order. Without a dispatcher, the actions are
+// run immediately.
+func (d *Dispatcher) Dispatch(ctx context.Context, s *discordgo.Session, m *discordgo.Message, actions []Action) {
+	if len(actions) == 0 {
+		return
+	}
+	if d == nil || d.pool == nil {
+		d.run(ctx, actions)
+		return
+	}
+
+	err := d.pool.Submit(ctx, []string{userKey(m.Author.ID)}, func(ctx context.Context) {
+		d.run(ctx, actions)
+	})
+	if err == nil {
+		return
+	}
+
+	fields := ctxutil.ZapFields(ctx)
+	if !errors.Is(err, workpool.ErrQueueFull) {
+		logger.With(zap.Error(err)).Error("Failed to queue actions", fields...)
+		return
+	}
+	logger.With(zap.Error(err)).Warn("Dropped actions for message; queue is full", fields...)
+	if _, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content:         busyMessage,
+		Reference:       m.Reference(),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}); err != nil {
+		logger.With(zap.Error(err)).Warn("Failed to reply that the queue is full", fields...)
+	}
+}
+
+// run executes actions in order, each within the action timeout
+func (d *Dispatcher) run(ctx context.Context, actions []Action) {
+	for _, action := range actions {
+		actionCtx, cancel := ctx, context.CancelFunc(func() {})
+		if d != nil && d.timeout > 0 {
+			actionCtx, cancel = context.WithTimeout(ctx, d.timeout)
+		}
+		start := time.Now()
+		action.Execute(actionCtx)
+		if errors.Is(actionCtx.Err(), context.DeadlineExceeded) {
+			logger.With(zap.String(zapkey.Action, fmt.Sprint(action)), zap.Duration(zapkey.Duration, time.Since(start))).
+				Warn("Action timed out", ctxutil.ZapFields(ctx)...)
+		}
+		cancel()
+	}
+}
+
+// userKey is the ordering key of a user's messages
+func userKey(userID string) string {
+	return "user:" + userID
+}
@@ -0,0 +1,99 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"discordbot/ledger"
+	"discordbot/spotify/track"
+)
+
+// messageLinkFormat is the format of a link to a Discord message (guild, channel, message)
+const messageLinkFormat = "https://discord.com/channels/%s/%s/%s"
+
+// replyDuplicates replies to a submitted message explaining which of its tracks were
+// already in the playlist and who originally added them
+func replyDuplicates(_ context.Context, s *discordgo.Session, channelID, messageID string, results []track.Result) error {
+	content := duplicatesMessage(results)
+	if content == "" {
+		return nil
+	}
+	_, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content: content,
+		Reference: &discordgo.MessageReference{
+			ChannelID: channelID,
+			MessageID: messageID,
+		},
+		// Credit the original submitter without pinging them
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reply about duplicates: %w", err)
+	}
+	return nil
+}
+
+// claimOwnDuplicates marks tracks that are only duplicates because this message already added
+// them (e.g. when it is resubmitted after an edit) as added, so they are not reported as duplicates
+func claimOwnDuplicates(results []track.Result, messageID string) []track.Result {
+	for i, result := range results {
+		if result.Status == track.StatusDuplicate && result.Original != nil &&
+			messageID != "" && result.Original.MessageID == messageID {
+			results[i].Status = track.StatusAdded
+		}
+	}
+	return results
+}
+
+// duplicatesMessage describes the duplicate tracks in results, or returns "" if there are none.
+// Duplicates from the same album or playlist link are described together.
+func duplicatesMessage(results []track.Result) string {
+	var lines []string
+	var collections []string
+	collectionCounts := make(map[string]int)
+	for _, result := range results {
+		if result.Status != track.StatusDuplicate {
+			continue
+		}
+		if result.Link != "" && track.ExtractTrackID(result.Link) == "" {
+			if collectionCounts[result.Link] == 0 {
+				collections = append(collections, result.Link)
+			}
+			collectionCounts[result.Link]++
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s is already in the playlist — %s",
+			statusEmojis[track.StatusDuplicate], track.URL(result.TrackID), originalCredit(result.Original)))
+	}
+	for _, link := range collections {
+		lines = append(lines, fmt.Sprintf("%s %d tracks from %s were already in the playlist.",
+			statusEmojis[track.StatusDuplicate], collectionCounts[link], link))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// originalCredit describes who originally added a track and when
+func originalCredit(entry *ledger.Entry) string {
+	if entry == nil {
+		return "it was added outside the bot."
+	}
+	credit := fmt.Sprintf("originally added by <@%s> on <t:%d:f>", entry.UserID, entry.AddedAt.Unix())
+	if link := messageLink(entry.GuildID, entry.ChannelID, entry.MessageID); link != "" {
+		credit = fmt.Sprintf("%s in %s", credit, link)
+	}
+	return credit + "."
+}
+
+// messageLink returns a link to a Discord message, or "" if the message is unknown
+func messageLink(guildID, channelID, messageID string) string {
+	if channelID == "" || messageID == "" {
+		return ""
+	}
+	if guildID == "" {
+		guildID = "@me"
+	}
+	return fmt.Sprintf(messageLinkFormat, guildID, channelID, messageID)
+}
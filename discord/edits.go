@@ -0,0 +1,169 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/track"
+	"discordbot/utils/ctxutil"
+)
+
+// HandleUpdate handles message edits, adding tracks linked by the edit to the playlist
+func (h *MessageHandler) HandleUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	if s == nil {
+		logger.Error("session is nil")
+		return
+	}
+	if m == nil || m.Message == nil {
+		logger.Error("message update is nil")
+		return
+	}
+
+	ctx, fields := ctxutil.WithZapFields(
+		ctxutil.WithGuildID(context.Background(), m.GuildID),
+		zap.String(zapkey.GuildID, m.GuildID),
+		zap.String(zapkey.ChannelID, m.ChannelID),
+		zap.String(zapkey.ID, m.ID),
+		zap.String(zapkey.Type, "message_update"),
+	)
+
+	msg := newRuleMessage(s, m.Message)
+	if !h.currentRules().MayAddTracks(msg) {
+		return
+	}
+	// Updates without content or author are embed unfurls and other partial updates
+	if m.Content == "" || m.Author == nil || m.Author.Bot {
+		return
+	}
+
+	newURLs, ok := extractSongURLs(m.Content)
+	if !ok {
+		return
+	}
+
+	// Only add links that the edit introduced. If the message was not cached before the edit,
+	// resubmit every link; tracks this message already added are recognised as its own.
+	var addedURLs []string
+	if m.BeforeUpdate != nil {
+		if m.BeforeUpdate.Content == m.Content {
+			return
+		}
+		oldURLs, _ := extractSongURLs(m.BeforeUpdate.Content)
+		oldIDs := make(map[string]struct{}, len(oldURLs))
+		for _, url := range oldURLs {
+			oldIDs[linkKey(url)] = struct{}{}
+		}
+		for _, url := range newURLs {
+			if _, ok := oldIDs[linkKey(url)]; !ok {
+				addedURLs = append(addedURLs, url)
+			}
+		}
+	} else {
+		addedURLs = newURLs
+	}
+	if len(addedURLs) == 0 {
+		logger.Debug("Edit did not add any tracks", fields...)
+		return
+	}
+
+	ctx, fields = ctxutil.WithZapFields(
+		ctx,
+		zap.String(zapkey.UserName, m.Author.Username),
+		zap.String(zapkey.UserID, m.Author.ID),
+	)
+	logger.With(zap.Strings(zapkey.TrackURLs, addedURLs)).Info("Edited message added tracks", fields...)
+
+	// Add the new links wherever the rules matching the edited message add tracks
+	var actions []Action
+	for _, ruleAction := range h.currentRules().Evaluate(msg) {
+		if ruleAction.Name != ActionAddTracksToPlaylist {
+			continue
+		}
+		action := &AddTracksToPlaylist{
+			session:       s,
+			event:         &discordgo.MessageCreate{Message: m.Message},
+			playlistAdder: h.playlistAdder,
+			guilds:        h.guilds,
+			confirmations: h.confirmations,
+			playlistID:    ruleAction.Params["playlist"],
+			trackURLs:     addedURLs,
+		}
+		actions = append(actions, action)
+	}
+	h.dispatcher.Dispatch(ctx, s, m.Message, actions)
+}
+
+// HandleDelete handles message deletions. Retries of the message's tracks still queued are given
+// up on, and the tracks already added are removed from the playlist if the message was deleted
+// within the grace period and nobody else submitted them.
+func (h *MessageHandler) HandleDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	if s == nil {
+		logger.Error("session is nil")
+		return
+	}
+	if m == nil || m.Message == nil {
+		logger.Error("message delete is nil")
+		return
+	}
+	if h.playlistRemover == nil || !h.currentRules().MayAddTracks(newRuleMessage(s, m.Message)) {
+		return
+	}
+
+	ctx, fields := ctxutil.WithZapFields(
+		ctxutil.WithGuildID(context.Background(), m.GuildID),
+		zap.String(zapkey.GuildID, m.GuildID),
+		zap.String(zapkey.ChannelID, m.ChannelID),
+		zap.String(zapkey.ID, m.ID),
+		zap.String(zapkey.Type, "message_delete"),
+	)
+	logger.Info("Handling deleted message", fields...)
+
+	// The tracks of a deleted message must not be added later, whatever the grace period
+	if _, err := h.playlistRemover.CancelQueued(ctx, m.ID); err != nil {
+		logger.With(zap.Error(err)).Error("Failed to cancel queued tracks for deleted message", fields...)
+	}
+
+	gracePeriod := h.guilds.Guild(m.GuildID).DeleteGracePeriod
+	if gracePeriod <= 0 {
+		return
+	}
+	removed, err := h.playlistRemover.RemoveSubmission(ctx, m.ID, time.Now().Add(-gracePeriod))
+	if err != nil {
+		logger.With(zap.Error(err)).Error("Failed to remove tracks for deleted message", fields...)
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	links := make([]string, 0, len(removed))
+	for _, trackID := range removed {
+		links = append(links, track.URL(trackID))
+	}
+	message := fmt.Sprintf("🗑️ Removed from the playlist since the message was deleted: %s", strings.Join(links, " "))
+	if _, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content: message,
+		Flags:   discordgo.MessageFlagsSuppressEmbeds,
+	}); err != nil {
+		logger.With(zap.Error(err)).Warn("Failed to announce removed tracks", fields...)
+	}
+}
+
+// linkKey identifies what a Spotify link refers to, ignoring query parameters
+func linkKey(url string) string {
+	if id := track.ExtractTrackID(url); id != "" {
+		return "track:" + id
+	}
+	if id := track.ExtractAlbumID(url); id != "" {
+		return "album:" + id
+	}
+	if id := track.ExtractPlaylistID(url); id != "" {
+		return "playlist:" + id
+	}
+	return url
+}
@@ -16,12 +16,12 @@ import (
 
 // InteractionSessionHandler handles interactions
 type InteractionSessionHandler struct {
-	// TODO: Config
+	router *Router
 }
 
-// NewInteractionSessionHandler creates a new interaction session handler
-func NewInteractionSessionHandler() *InteractionSessionHandler {
-	return &InteractionSessionHandler{}
+// NewInteractionSessionHandler creates a new interaction session handler dispatching to the given router
+func NewInteractionSessionHandler(router *Router) *InteractionSessionHandler {
+	return &InteractionSessionHandler{router: router}
 }
 
 // String returns a string representation of the interaction session handler
@@ -34,6 +34,9 @@ func (h *InteractionSessionHandler) Add(session *discordgo.Session) error {
 	if session == nil {
 		return fmt.Errorf("session is nil")
 	}
+	if h.router == nil {
+		return fmt.Errorf("router is nil")
+	}
 	session.AddHandler(h.Handle)
 	return nil
 }
@@ -49,23 +52,22 @@ func (h *InteractionSessionHandler) Handle(s *discordgo.Session, i *discordgo.In
 		return
 	}
 
-	_, fields := ctxutil.WithZapFields(
-		context.Background(),
+	ctx, fields := ctxutil.WithZapFields(
+		ctxutil.WithGuildID(context.Background(), i.GuildID),
+		zap.String(zapkey.GuildID, i.GuildID),
 		zap.String(zapkey.Type, i.Type.String()),
 		zap.String(zapkey.ID, i.ID),
+		zap.String(zapkey.UserID, interactionUserID(i)),
 	)
 
 	logger.Info("Received interaction", fields...)
 
-	// Determine interaction responder function
-	switch i.Type {
-	case discordgo.InteractionPing:
+	// Pings are answered directly; everything else goes through the router
+	if i.Type == discordgo.InteractionPing {
 		h.ping(s, i)
-	case discordgo.InteractionApplicationCommand:
-		h.slashCommand(s, i)
-	default:
-		logger.Error("no responder for interaction type", fields...)
+		return
 	}
+	h.router.Route(ctx, s, i)
 }
 
 // ping handles ping interactions
@@ -82,33 +84,11 @@ func (h *InteractionSessionHandler) ping(s *discordgo.Session, i *discordgo.Inte
 	}
 }
 
-// slashCommand handles slash command interactions
-func (h *InteractionSessionHandler) slashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	data := i.ApplicationCommandData()
-	logger.Info("Handling slash command", zap.String(zapkey.Command, data.Name))
-
-	switch data.Name {
-	case testCommand:
-		h.testCommand(s, i)
-	case challengeCommand:
-		h.challengeCommand(s, i)
-	default:
-		logger.Error("unknown slash command", zap.String(zapkey.Command, data.Name))
-	}
-}
-
-// testCommand handles the /test slash command interaction
-func (h *InteractionSessionHandler) testCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	var userID string
-	if i.User != nil {
-		userID = i.User.ID
-	} else if i.Member != nil && i.Member.User != nil {
-		userID = i.Member.User.ID
-	}
-
+// handleTestCommand handles the /test slash command interaction
+func handleTestCommand(_ context.Context, _ *discordgo.Session, i *discordgo.InteractionCreate) (*discordgo.InteractionResponse, error) {
 	// TODO: Make this configurable
 	var message string
-	switch userID {
+	switch interactionUserID(i) {
 	case id.UserIDGio:
 		message = "GIOGIOGIO"
 	case id.UserIDRehan:
@@ -116,21 +96,11 @@ func (h *InteractionSessionHandler) testCommand(s *discordgo.Session, i *discord
 	default:
 		message = "Test message"
 	}
-
-	response := discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: message,
-		},
-	}
-
-	if err := s.InteractionRespond(i.Interaction, &response); err != nil {
-		logger.Error("failed to respond to test command", zap.Error(err))
-	}
+	return messageResponse(message), nil
 }
 
-// challengeCommand handles the /challenge slash command interaction
-func (h *InteractionSessionHandler) challengeCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+// handleChallengeCommand handles the /challenge slash command interaction
+func handleChallengeCommand(_ context.Context, _ *discordgo.Session, i *discordgo.InteractionCreate) (*discordgo.InteractionResponse, error) {
 	msg := "Challenge me once you're worthy."
 	data := i.ApplicationCommandData()
 	if len(data.Options) > 0 {
@@ -142,14 +112,5 @@ func (h *InteractionSessionHandler) challengeCommand(s *discordgo.Session, i *di
 			msg,
 		)
 	}
-	response := discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: msg,
-		},
-	}
-
-	if err := s.InteractionRespond(i.Interaction, &response); err != nil {
-		logger.Error("failed to respond to challenge command", zap.Error(err))
-	}
+	return messageResponse(msg), nil
 }
@@ -3,43 +3,60 @@ package discord
 import (
 	"context"
 	"fmt"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/jdcukier/spotify/v2"
 	"go.uber.org/zap"
 
-	"discordbot/constants/envvar"
 	"discordbot/constants/zapkey"
 	"discordbot/discord/channel"
+	"discordbot/discord/config"
 	"discordbot/log"
+	"discordbot/spotify/convert"
 	"discordbot/spotify/track"
 	"discordbot/utils/ctxutil"
 )
 
 // --- Constants ---
 
+// Names of the built-in actions
 const (
-	ActionReply               = "Reply"
-	ActionAddTracksToPlaylist = "Add Tracks To Playlist"
+	ActionReply               = "Reply"                  // Params: message (echoes the message when empty)
+	ActionAddTracksToPlaylist = "Add Tracks To Playlist" // Params: playlist (the channel's playlist when empty)
 )
 
 // --- Interfaces ---
 
 // PlaylistAdder is an interface for adding tracks to a playlist
 type PlaylistAdder interface {
-	AddTracksToPlaylist(ctx context.Context, userID string, playlistID string, trackURLs []string) error
+	AddTracksToPlaylist(ctx context.Context, sub track.Submission) ([]track.Result, error)
+}
+
+// GuildConfigs is an interface for looking up the configuration of the guild a submission was made in
+type GuildConfigs interface {
+	Guild(guildID string) *config.GuildConfig
+}
+
+// PlaylistRemover is an interface for withdrawing the tracks submitted in a message
+type PlaylistRemover interface {
+	RemoveSubmission(ctx context.Context, messageID string, addedAfter time.Time) ([]spotify.ID, error)
+	CancelQueued(ctx context.Context, messageID string) (int, error)
 }
 
 // --- Message Sender ---
 
-// SendMessage sends a message to a channel
+// SendMessage sends a message to the channel of the given type in the guild the action was
+// triggered in (see ctxutil.WithGuildID), or the default guild's if the context has none
 func (c *Client) SendMessage(ctx context.Context, channelType string, message string) error {
 	if err := c.Validate(); err != nil {
 		return fmt.Errorf("failed to validate discord client: %w", err)
 	}
-	channelID, ok := c.config.ChannelIDs[channel.NewType(channelType)]
-	if !ok {
-		return fmt.Errorf("no channel configured for type: %s", channelType)
+	guildID := ctxutil.GuildID(ctx)
+	channelID, ok := c.config.Guild(guildID).ChannelIDs[channel.NewType(channelType)]
+	if !ok || channelID == "" {
+		return fmt.Errorf("no channel configured for type %s in guild %q", channelType, guildID)
 	}
 
 	_, err := c.session.ChannelMessageSend(channelID, message)
@@ -53,13 +70,47 @@ func (c *Client) SendMessage(ctx context.Context, channelType string, message st
 
 // MessageHandler handles message events
 type MessageHandler struct {
-	playlistAdder PlaylistAdder
-	actionIDs     ChannelActions // Map of channel IDs to actions to take for that channel
+	playlistAdder   PlaylistAdder
+	playlistRemover PlaylistRemover
+	confirmations   *Confirmations // Asks submitters to confirm large albums and playlists
+	guilds          GuildConfigs   // Playlists and delete grace period of each guild
+	dispatcher      *Dispatcher    // Runs actions off the event handler; nil runs them inline
+
+	mu    sync.RWMutex
+	rules Rules // Decide the actions to take for each message
 }
 
 // NewMessageHandler creates a new message handler
-func NewMessageHandler(playlistAdder PlaylistAdder, actions ChannelActions) *MessageHandler {
-	return &MessageHandler{playlistAdder: playlistAdder, actionIDs: actions}
+func NewMessageHandler(
+	playlistAdder PlaylistAdder,
+	playlistRemover PlaylistRemover,
+	confirmations *Confirmations,
+	guilds GuildConfigs,
+	rules Rules,
+	dispatcher *Dispatcher,
+) *MessageHandler {
+	return &MessageHandler{
+		playlistAdder:   playlistAdder,
+		playlistRemover: playlistRemover,
+		confirmations:   confirmations,
+		guilds:          guilds,
+		dispatcher:      dispatcher,
+		rules:           rules,
+	}
+}
+
+// SetRules replaces the rules messages are evaluated against, e.g. when the configuration is reloaded
+func (h *MessageHandler) SetRules(rules Rules) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rules = rules
+}
+
+// currentRules returns the rules messages are evaluated against
+func (h *MessageHandler) currentRules() Rules {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.rules
 }
 
 // String returns a string representation of the handler
@@ -73,6 +124,8 @@ func (h *MessageHandler) Add(session *discordgo.Session) error {
 		return fmt.Errorf("session is nil")
 	}
 	session.AddHandler(h.Handle)
+	session.AddHandler(h.HandleUpdate)
+	session.AddHandler(h.HandleDelete)
 	return nil
 }
 
@@ -85,7 +138,8 @@ func (h *MessageHandler) Handle(s *discordgo.Session, m *discordgo.MessageCreate
 
 	// Zap logging Fields
 	ctx, fields := ctxutil.WithZapFields(
-		context.Background(),
+		ctxutil.WithGuildID(context.Background(), m.GuildID),
+		zap.String(zapkey.GuildID, m.GuildID),
 		zap.String(zapkey.ChannelID, m.ChannelID),
 		zap.String(zapkey.ID, m.ID),
 		zap.String(zapkey.Type, "message"),
@@ -99,7 +153,7 @@ func (h *MessageHandler) Handle(s *discordgo.Session, m *discordgo.MessageCreate
 	}
 
 	ctx, fields = ctxutil.WithZapFields(
-		context.Background(),
+		ctx,
 		zap.String(zapkey.UserName, m.Author.Username),
 		zap.String(zapkey.UserID, m.Author.ID),
 	)
@@ -117,34 +171,26 @@ func (h *MessageHandler) Handle(s *discordgo.Session, m *discordgo.MessageCreate
 
 	logger.Info("Received message", fields...)
 
-	actionIDs, ok := h.actionIDs[m.ChannelID]
-	if !ok {
-		// Nothing to do, log a debug message anr return
-		logger.Debug("Received message for unknown channel", fields...)
+	ruleActions := h.currentRules().Evaluate(newRuleMessage(s, m.Message))
+	if len(ruleActions) == 0 {
+		// Nothing to do, log a debug message and return
+		logger.Debug("No rule matched message", fields...)
 		return
 	}
 
-	// List of actions to perform
+	// Create the actions the matching rules trigger, in order
 	var actions []Action
-
-	// Determine actions to perform
-	for _, actionID := range actionIDs {
-		switch actionID {
-		case ActionReply:
-			reply := &Reply{session: s, event: m}
-			actions = append(actions, reply)
-		case ActionAddTracksToPlaylist:
-			addTracksToPlaylist := &AddTracksToPlaylist{session: s, event: m, playlistAdder: h.playlistAdder}
-			actions = append(actions, addTracksToPlaylist)
-		default:
-			logger.With(zap.String(zapkey.Action, actionID)).Error("received unknown action", fields...)
+	for _, ruleAction := range ruleActions {
+		action, err := newAction(ruleAction.Name, ActionRequest{Session: s, Event: m, Params: ruleAction.Params, handler: h})
+		if err != nil {
+			logger.With(zap.Error(err), zap.String(zapkey.Action, ruleAction.Name)).Error("Failed to create action", fields...)
+			continue
 		}
+		actions = append(actions, action)
 	}
 
 	// Perform actions
-	for _, action := range actions {
-		action.Execute(ctx)
-	}
+	h.dispatcher.Dispatch(ctx, s, m.Message, actions)
 }
 
 // Reply handles replying to a message
@@ -183,6 +229,14 @@ func (r *Reply) Execute(ctx context.Context) {
 	logger.With(zap.String(zapkey.Reply, r.response)).Info("Sent reply", fields...)
 }
 
+// extractSongURLs extracts the Spotify links and the song links from other platforms in content
+func extractSongURLs(content string) ([]string, bool) {
+	spotifyURLs, _ := track.ExtractURLs(content)
+	otherURLs, _ := convert.ExtractURLs(content)
+	urls := append(spotifyURLs, otherURLs...)
+	return urls, len(urls) > 0
+}
+
 // validateMessage validates the received message
 func validateMessage(m *discordgo.MessageCreate) error {
 	if m == nil {
@@ -205,6 +259,10 @@ type AddTracksToPlaylist struct {
 	session       *discordgo.Session
 	event         *discordgo.MessageCreate
 	playlistAdder PlaylistAdder
+	guilds        GuildConfigs
+	confirmations *Confirmations
+	playlistID    string   // Playlist to add to; the channel's playlist when empty
+	trackURLs     []string // Tracks to add; extracted from the message content when nil
 }
 
 // String returns a string representation of the action
@@ -222,9 +280,12 @@ func (a *AddTracksToPlaylist) Execute(ctx context.Context) {
 	}
 
 	// Extract track URLs from message
-	trackURLs, ok := track.ExtractURLs(a.event.Content)
+	trackURLs, ok := a.trackURLs, len(a.trackURLs) > 0
+	if a.trackURLs == nil {
+		trackURLs, ok = extractSongURLs(a.event.Content)
+	}
 	if !ok {
-		// Not an error, just not a message with Spotify tracks
+		// Not an error, just not a message with songs
 		logger.Info("No tracks found in message", fields...)
 		return
 	}
@@ -237,10 +298,9 @@ func (a *AddTracksToPlaylist) Execute(ctx context.Context) {
 	// Log if we found any tracks
 	logger.With(zap.Int(zapkey.Count, len(trackURLs))).Info("Found Spotify tracks", fields...)
 
-	// TODO: Make this more configurable to support multiple playlists
-	playlistID := os.Getenv(envvar.SpotifyPlaylistID)
+	playlistID := a.playlist()
 	if playlistID == "" {
-		logger.With(zap.Error(fmt.Errorf("failed to retrieve playlist ID from env var"))).Error("Playlist ID is empty", fields...)
+		logger.With(zap.Error(fmt.Errorf("no playlist configured for channel"))).Error("Playlist ID is empty", fields...)
 		return
 	}
 
@@ -249,17 +309,49 @@ func (a *AddTracksToPlaylist) Execute(ctx context.Context) {
 		zap.String(zapkey.PlaylistID, playlistID),
 	)
 
-	if err := a.playlistAdder.AddTracksToPlaylist(ctx, a.event.Author.ID, playlistID, trackURLs); err != nil {
+	sub := track.Submission{
+		UserID:         a.event.Author.ID,
+		GuildID:        a.event.GuildID,
+		ChannelID:      a.event.ChannelID,
+		MessageID:      a.event.ID,
+		PlaylistID:     playlistID,
+		TrackURLs:      trackURLs,
+		TagPlaylistIDs: a.tagPlaylists(ctx),
+	}
+	results, err := a.playlistAdder.AddTracksToPlaylist(ctx, sub)
+	if err != nil {
 		logger.With(zap.Error(err)).Error("Failed to add tracks to playlist", fields...)
-		return
+	}
+	results = claimOwnDuplicates(results, a.event.ID)
+
+	// Show the outcome on the original message
+	if err := setStatusReaction(ctx, a.session, a.event.ChannelID, a.event.ID, track.Summarize(results)); err != nil {
+		logger.With(zap.Error(err)).Warn("Failed to react with submission status", fields...)
+	}
+	if err := replyDuplicates(ctx, a.session, a.event.ChannelID, a.event.ID, results); err != nil {
+		logger.With(zap.Error(err)).Warn("Failed to reply about duplicate tracks", fields...)
+	}
+	if err := a.confirmations.Prompt(ctx, a.session, sub, results); err != nil {
+		logger.With(zap.Error(err)).Warn("Failed to ask for confirmation", fields...)
 	}
 }
 
+// playlist returns the playlist to add to
+func (a *AddTracksToPlaylist) playlist() string {
+	if a.playlistID != "" || a.guilds == nil || a.event == nil {
+		return a.playlistID
+	}
+	return a.guilds.Guild(a.event.GuildID).PlaylistForChannel(a.event.ChannelID)
+}
+
 // Validate validates the action
 func (a *AddTracksToPlaylist) Validate() error {
 	if a.playlistAdder == nil {
 		return fmt.Errorf("playlist adder is nil")
 	}
+	if a.guilds == nil {
+		return fmt.Errorf("guild configs are nil")
+	}
 	if a.event == nil {
 		return fmt.Errorf("message is nil")
 	}
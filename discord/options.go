@@ -26,3 +26,18 @@ func WithSession(session *discordgo.Session) Option {
 		c.session = session
 	}
 }
+
+// WithCommands sets the slash commands to register with Discord on start
+func WithCommands(commands *Commands) Option {
+	return func(c *Client) {
+		c.commands = commands
+	}
+}
+
+// WithConfirmations sets the store used to ask for confirmation of large albums and playlists
+// in submissions that complete asynchronously
+func WithConfirmations(confirmations *Confirmations) Option {
+	return func(c *Client) {
+		c.confirmations = confirmations
+	}
+}
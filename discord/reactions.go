@@ -0,0 +1,116 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/track"
+	"discordbot/utils/ctxutil"
+)
+
+// statusEmojis are the reactions used to show the outcome of a submitted message
+var statusEmojis = map[track.Status]string{
+	track.StatusAdded:             "✅",
+	track.StatusDuplicate:         "🔁",
+	track.StatusAuthPending:       "⏳",
+	track.StatusRetrying:          "🔄",
+	track.StatusFailed:            "❌",
+	track.StatusNeedsConfirmation: "❓",
+}
+
+// UpdateSubmission shows the outcome of a submission that completed asynchronously,
+// e.g. once the submitter has connected Spotify
+func (c *Client) UpdateSubmission(ctx context.Context, sub track.Submission, results []track.Result) error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("failed to validate discord client: %w", err)
+	}
+	if sub.MessageID == "" {
+		// Nothing to react to (e.g. slash command submissions), so the outcome is posted instead
+		if err := c.postResult(sub, results); err != nil {
+			return err
+		}
+		return c.confirmations.Prompt(ctx, c.session, sub, results)
+	}
+	results = claimOwnDuplicates(results, sub.MessageID)
+	if err := setStatusReaction(ctx, c.session, sub.ChannelID, sub.MessageID, track.Summarize(results)); err != nil {
+		return err
+	}
+	if err := replyDuplicates(ctx, c.session, sub.ChannelID, sub.MessageID, results); err != nil {
+		return err
+	}
+	return c.confirmations.Prompt(ctx, c.session, sub, results)
+}
+
+// postResult posts the outcome of a submission made without a message in its channel, mentioning
+// the submitter. Submissions needing confirmation are left to the confirmation prompt.
+func (c *Client) postResult(sub track.Submission, results []track.Result) error {
+	if track.Summarize(results) == track.StatusNeedsConfirmation {
+		return nil
+	}
+	data := resultResponse(sub.UserID, sub.TrackURLs, results).Data
+	_, err := c.session.ChannelMessageSendComplex(sub.ChannelID, &discordgo.MessageSend{
+		Content:         fmt.Sprintf("<@%s> %s", sub.UserID, data.Content),
+		AllowedMentions: &discordgo.MessageAllowedMentions{Users: []string{sub.UserID}},
+		Flags:           discordgo.MessageFlagsSuppressEmbeds,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to post submission outcome: %w", err)
+	}
+	return nil
+}
+
+// setStatusReaction reacts to a message with the emoji for status, replacing any other
+// status reaction the bot previously left on it
+func setStatusReaction(ctx context.Context, s *discordgo.Session, channelID, messageID string, status track.Status) error {
+	emoji, ok := statusEmojis[status]
+	if !ok {
+		return fmt.Errorf("no reaction for status %q", status)
+	}
+	_, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.Status, string(status)))
+
+	message, err := s.ChannelMessage(channelID, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch message: %w", err)
+	}
+
+	// Remove stale status reactions left by the bot
+	alreadyReacted := false
+	for _, reaction := range message.Reactions {
+		if reaction.Emoji == nil || !reaction.Me {
+			continue
+		}
+		if reaction.Emoji.Name == emoji {
+			alreadyReacted = true
+			continue
+		}
+		if !isStatusEmoji(reaction.Emoji.Name) {
+			continue
+		}
+		if err := s.MessageReactionRemove(channelID, messageID, reaction.Emoji.Name, "@me"); err != nil {
+			logger.With(zap.Error(err)).Warn("Failed to remove stale status reaction", fields...)
+		}
+	}
+
+	if alreadyReacted {
+		return nil
+	}
+	if err := s.MessageReactionAdd(channelID, messageID, emoji); err != nil {
+		return fmt.Errorf("failed to add reaction: %w", err)
+	}
+	logger.Info("Updated submission status reaction", fields...)
+	return nil
+}
+
+// isStatusEmoji reports whether emoji is one of the submission status reactions
+func isStatusEmoji(emoji string) bool {
+	for _, e := range statusEmojis {
+		if e == emoji {
+			return true
+		}
+	}
+	return false
+}
@@ -2,20 +2,24 @@ package discord
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 // ReadyHandler fires when the bot comes online (Discord gateway READY event)
 type ReadyHandler struct {
-	channelID       string
-	message         string
+	channelIDs []string // Channels to announce the bot in, one per guild
+
+	mu               sync.Mutex
+	message          string
 	listeningMessage string
+	session          *discordgo.Session // Set once the bot is online
 }
 
 // NewReadyHandler creates a new ready handler
-func NewReadyHandler(channelID, message, listeningMessage string) *ReadyHandler {
-	return &ReadyHandler{channelID: channelID, message: message, listeningMessage: listeningMessage}
+func NewReadyHandler(channelIDs []string, message, listeningMessage string) *ReadyHandler {
+	return &ReadyHandler{channelIDs: channelIDs, message: message, listeningMessage: listeningMessage}
 }
 
 // String returns a string representation of the handler
@@ -29,17 +33,47 @@ func (h *ReadyHandler) Add(session *discordgo.Session) error {
 		return fmt.Errorf("session is nil")
 	}
 	session.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
-		if _, err := s.ChannelMessageSend(h.channelID, h.message); err != nil {
-			logger.Error("failed to send startup message: " + err.Error())
+		h.mu.Lock()
+		h.session = s
+		message, listeningMessage := h.message, h.listeningMessage
+		h.mu.Unlock()
+
+		for _, channelID := range h.channelIDs {
+			if _, err := s.ChannelMessageSend(channelID, message); err != nil {
+				logger.Error("failed to send startup message: " + err.Error())
+			}
 		}
-		if err := s.UpdateStatusComplex(discordgo.UpdateStatusData{
-			Activities: []*discordgo.Activity{{
-				Name: h.listeningMessage,
-				Type: discordgo.ActivityTypeListening,
-			}},
-		}); err != nil {
+		if err := updatePresence(s, listeningMessage); err != nil {
 			logger.Error("failed to set presence: " + err.Error())
 		}
 	})
 	return nil
 }
+
+// SetMessages replaces the ready message and the bot's presence. The presence is updated
+// immediately if the bot is online; the ready message is used the next time it comes online.
+func (h *ReadyHandler) SetMessages(message, listeningMessage string) error {
+	h.mu.Lock()
+	changed := h.listeningMessage != listeningMessage
+	h.message, h.listeningMessage = message, listeningMessage
+	session := h.session
+	h.mu.Unlock()
+
+	if session == nil || !changed {
+		return nil
+	}
+	if err := updatePresence(session, listeningMessage); err != nil {
+		return fmt.Errorf("failed to set presence: %w", err)
+	}
+	return nil
+}
+
+// updatePresence shows the bot as listening to listeningMessage
+func updatePresence(s *discordgo.Session, listeningMessage string) error {
+	return s.UpdateStatusComplex(discordgo.UpdateStatusData{
+		Activities: []*discordgo.Activity{{
+			Name: listeningMessage,
+			Type: discordgo.ActivityTypeListening,
+		}},
+	})
+}
@@ -0,0 +1,434 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/utils/ctxutil"
+)
+
+const (
+	// deferAfter is how long a handler may run before the router sends a deferred response.
+	// Discord requires an initial response within 3 seconds; the margin covers network latency.
+	deferAfter = 2 * time.Second
+
+	// interactionTimeout bounds handler execution. Interaction tokens are valid for 15 minutes,
+	// after which follow-ups can no longer be delivered.
+	interactionTimeout = 14 * time.Minute
+
+	// autocompleteTimeout bounds autocomplete handlers, which cannot be deferred
+	autocompleteTimeout = 2500 * time.Millisecond
+
+	// customIDSeparator separates the route prefix of a custom ID from its payload
+	customIDSeparator = ":"
+)
+
+// InteractionHandler handles an interaction and returns the response to send to Discord.
+// A nil response means the handler responded itself with respondNow, or has nothing to say.
+type InteractionHandler func(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) (*discordgo.InteractionResponse, error)
+
+// Route describes how an interaction is handled
+type Route struct {
+	Handler InteractionHandler
+
+	// Defer sends a deferred response before running the handler. Set this for handlers
+	// that are known to be slow (e.g. anything touching the Spotify worker); other handlers
+	// are deferred automatically if they do not finish within deferAfter.
+	Defer bool
+
+	// Ephemeral makes the deferred response and error messages visible only to the user
+	Ephemeral bool
+}
+
+// Router dispatches interactions to handlers by command name or custom ID prefix
+type Router struct {
+	commands *Commands
+
+	mu         sync.RWMutex
+	components map[string]Route // Keyed by custom ID prefix
+	modals     map[string]Route // Keyed by custom ID prefix
+}
+
+// NewRouter creates a new router serving the given slash commands
+func NewRouter(commands *Commands) *Router {
+	return &Router{
+		commands:   commands,
+		components: make(map[string]Route),
+		modals:     make(map[string]Route),
+	}
+}
+
+// HandleComponent routes message component interactions (buttons, select menus) whose
+// custom ID starts with prefix to the given route
+func (r *Router) HandleComponent(prefix string, route Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.components[prefix] = route
+}
+
+// HandleModal routes modal submit interactions whose custom ID starts with prefix to the given route
+func (r *Router) HandleModal(prefix string, route Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.modals[prefix] = route
+}
+
+// CustomID builds a component or modal custom ID that routes to prefix and carries payload
+func CustomID(prefix string, payload ...string) string {
+	return strings.Join(append([]string{prefix}, payload...), customIDSeparator)
+}
+
+// splitCustomID splits a custom ID into its route prefix and payload
+func splitCustomID(customID string) (string, []string) {
+	parts := strings.Split(customID, customIDSeparator)
+	return parts[0], parts[1:]
+}
+
+// CustomIDPayload returns the payload of the custom ID of a component or modal interaction
+func CustomIDPayload(i *discordgo.InteractionCreate) []string {
+	var customID string
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		customID = i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		customID = i.ModalSubmitData().CustomID
+	}
+	_, payload := splitCustomID(customID)
+	return payload
+}
+
+// Route dispatches the interaction to its handler and delivers the response
+func (r *Router) Route(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) {
+	fields := ctxutil.ZapFields(ctx)
+
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		name := i.ApplicationCommandData().Name
+		ctx, fields = ctxutil.WithZapFields(ctx, zap.String(zapkey.Command, name))
+		cmd, ok := r.commands.Get(name)
+		if !ok {
+			logger.Error("unknown slash command", fields...)
+			return
+		}
+		r.dispatch(ctx, s, i, Route{Handler: cmd.Handler, Defer: cmd.Defer, Ephemeral: cmd.Ephemeral})
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		name := i.ApplicationCommandData().Name
+		ctx, fields = ctxutil.WithZapFields(ctx, zap.String(zapkey.Command, name))
+		cmd, ok := r.commands.Get(name)
+		if !ok || cmd.Autocomplete == nil {
+			logger.Error("no autocomplete handler for command", fields...)
+			return
+		}
+		r.autocomplete(ctx, s, i, cmd.Autocomplete)
+
+	case discordgo.InteractionMessageComponent:
+		customID := i.MessageComponentData().CustomID
+		ctx, fields = ctxutil.WithZapFields(ctx, zap.String(zapkey.CustomID, customID))
+		route, ok := r.lookup(r.components, customID)
+		if !ok {
+			logger.Error("no handler for component", fields...)
+			return
+		}
+		r.dispatch(ctx, s, i, route)
+
+	case discordgo.InteractionModalSubmit:
+		customID := i.ModalSubmitData().CustomID
+		ctx, fields = ctxutil.WithZapFields(ctx, zap.String(zapkey.CustomID, customID))
+		route, ok := r.lookup(r.modals, customID)
+		if !ok {
+			logger.Error("no handler for modal", fields...)
+			return
+		}
+		r.dispatch(ctx, s, i, route)
+
+	default:
+		logger.Error("no responder for interaction type", fields...)
+	}
+}
+
+// lookup finds the route registered for the prefix of customID
+func (r *Router) lookup(routes map[string]Route, customID string) (Route, bool) {
+	prefix, _ := splitCustomID(customID)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	route, ok := routes[prefix]
+	return route, ok && route.Handler != nil
+}
+
+// handlerResult is the outcome of running an interaction handler
+type handlerResult struct {
+	response *discordgo.InteractionResponse
+	err      error
+}
+
+// dispatch runs the route's handler, sending a deferred response first if the handler is
+// slow, and delivers the handler's response as either the initial response or a follow-up
+func (r *Router) dispatch(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, route Route) {
+	fields := ctxutil.ZapFields(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, interactionTimeout)
+	defer cancel()
+	ctx = withInteraction(ctx, s, i)
+	p := interactionFromContext(ctx)
+
+	done := make(chan handlerResult, 1)
+	go func() {
+		response, err := route.Handler(ctx, s, i)
+		done <- handlerResult{response: response, err: err}
+	}()
+
+	if !route.Defer {
+		select {
+		case result := <-done:
+			r.respond(ctx, s, i, route, result)
+			return
+		case <-time.After(deferAfter):
+			logger.Info("Handler is slow; deferring interaction response", fields...)
+		}
+	}
+
+	// The handler may have responded itself in the meantime, in which case there is nothing to defer
+	if _, err := p.acknowledge(deferredResponse(i, route), true); err != nil {
+		logger.With(zap.Error(err)).Error("failed to send deferred response", fields...)
+		return
+	}
+	r.followUp(ctx, s, i, route, <-done)
+}
+
+// acknowledge sends response as the initial response to the interaction unless one was sent
+// already, and reports whether it was sent. deferral marks response as the router's deferral.
+func (p *pendingInteraction) acknowledge(response *discordgo.InteractionResponse, deferral bool) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.acknowledged {
+		return false, nil
+	}
+	if err := p.session.InteractionRespond(p.interaction, response); err != nil {
+		return false, err
+	}
+	p.acknowledged, p.deferred = true, deferral
+	return true, nil
+}
+
+// placeholderPending reports whether the router's deferral is still waiting to be replaced
+func (p *pendingInteraction) placeholderPending() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.deferred && !p.replaced
+}
+
+// respondNow lets a handler respond to the interaction itself before returning, e.g. to show a
+// modal. If the router has deferred the response, the deferral is replaced with the response
+// instead. Handlers responding this way return a nil response.
+func respondNow(ctx context.Context, response *discordgo.InteractionResponse) error {
+	p := interactionFromContext(ctx)
+	if p == nil {
+		return fmt.Errorf("no interaction to respond to")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case !p.acknowledged:
+		if err := p.session.InteractionRespond(p.interaction, response); err != nil {
+			return err
+		}
+		p.acknowledged = true
+	case p.deferred && !p.replaced && response.Data != nil:
+		if _, err := p.session.InteractionResponseEdit(p.interaction, webhookEdit(response.Data)); err != nil {
+			return err
+		}
+		p.replaced = true
+	default:
+		return fmt.Errorf("interaction was already answered")
+	}
+	p.once.Do(func() { close(p.responded) })
+	return nil
+}
+
+// respond delivers the handler result as the initial interaction response
+func (r *Router) respond(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, route Route, result handlerResult) {
+	fields := ctxutil.ZapFields(ctx)
+	response := result.response
+	if result.err != nil {
+		logger.With(zap.Error(result.err)).Error("interaction handler failed", fields...)
+		response = errorResponse(route)
+	}
+	if response == nil {
+		return
+	}
+	sent, err := interactionFromContext(ctx).acknowledge(response, false)
+	if err == nil && !sent {
+		if response.Data == nil {
+			return
+		}
+		// The handler responded itself, so the response is an additional message
+		_, err = s.FollowupMessageCreate(i.Interaction, true, webhookParams(response.Data))
+	}
+	if err != nil {
+		logger.With(zap.Error(err)).Error("failed to respond to interaction", fields...)
+		return
+	}
+	markResponded(ctx)
+}
+
+// followUp delivers the handler result after a deferred response has been sent
+func (r *Router) followUp(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, route Route, result handlerResult) {
+	fields := ctxutil.ZapFields(ctx)
+	response := result.response
+	if result.err != nil {
+		logger.With(zap.Error(result.err)).Error("interaction handler failed", fields...)
+		response = errorResponse(route)
+	}
+	placeholder := interactionFromContext(ctx).placeholderPending()
+	if response == nil || response.Data == nil {
+		if placeholder && i.Type != discordgo.InteractionMessageComponent {
+			// Nothing will replace the "thinking..." placeholder, so remove it
+			if err := s.InteractionResponseDelete(i.Interaction); err != nil {
+				logger.With(zap.Error(err)).Warn("failed to delete deferred response", fields...)
+			}
+		}
+		return
+	}
+
+	var err error
+	switch response.Type {
+	case discordgo.InteractionResponseChannelMessageWithSource:
+		if i.Type == discordgo.InteractionMessageComponent || !placeholder {
+			// The deferred update acknowledged the component, or the handler answered
+			// itself; the answer is a new message
+			_, err = s.FollowupMessageCreate(i.Interaction, true, webhookParams(response.Data))
+		} else {
+			// Replace the "thinking..." placeholder with the answer
+			_, err = s.InteractionResponseEdit(i.Interaction, webhookEdit(response.Data))
+		}
+	case discordgo.InteractionResponseUpdateMessage:
+		_, err = s.InteractionResponseEdit(i.Interaction, webhookEdit(response.Data))
+	default:
+		err = fmt.Errorf("response type %d cannot be sent after a deferred response", response.Type)
+	}
+	if err != nil {
+		logger.With(zap.Error(err)).Error("failed to send interaction follow-up", fields...)
+		return
+	}
+	markResponded(ctx)
+}
+
+// autocomplete runs an autocomplete handler, which must answer within Discord's window
+func (r *Router) autocomplete(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, handler InteractionHandler) {
+	fields := ctxutil.ZapFields(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, autocompleteTimeout)
+	defer cancel()
+
+	response, err := handler(ctx, s, i)
+	if err != nil {
+		// An empty suggestion list is better than Discord's generic failure message
+		logger.With(zap.Error(err)).Warn("autocomplete handler failed", fields...)
+		response = autocompleteResponse(nil)
+	}
+	if response == nil {
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, response); err != nil {
+		logger.With(zap.Error(err)).Error("failed to respond to autocomplete", fields...)
+	}
+}
+
+// --- Responses ---
+
+// messageResponse creates a response that posts a message in the interaction's channel
+func messageResponse(content string) *discordgo.InteractionResponse {
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: content},
+	}
+}
+
+// ephemeralResponse creates a response that only the invoking user can see
+func ephemeralResponse(content string) *discordgo.InteractionResponse {
+	response := messageResponse(content)
+	response.Data.Flags = discordgo.MessageFlagsEphemeral
+	return response
+}
+
+// autocompleteResponse creates a response offering the given choices
+func autocompleteResponse(choices []*discordgo.ApplicationCommandOptionChoice) *discordgo.InteractionResponse {
+	if choices == nil {
+		choices = []*discordgo.ApplicationCommandOptionChoice{}
+	}
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	}
+}
+
+// errorResponse creates the response sent when a handler fails
+func errorResponse(route Route) *discordgo.InteractionResponse {
+	if route.Ephemeral {
+		return ephemeralResponse("❌ Something went wrong handling that. Please try again.")
+	}
+	return messageResponse("❌ Something went wrong handling that. Please try again.")
+}
+
+// deferredResponse creates the deferred response appropriate for the interaction type
+func deferredResponse(i *discordgo.InteractionCreate, route Route) *discordgo.InteractionResponse {
+	if i.Type == discordgo.InteractionMessageComponent {
+		return &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredMessageUpdate}
+	}
+	response := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{},
+	}
+	if route.Ephemeral {
+		response.Data.Flags = discordgo.MessageFlagsEphemeral
+	}
+	return response
+}
+
+// webhookEdit converts response data into an edit of the original response
+func webhookEdit(data *discordgo.InteractionResponseData) *discordgo.WebhookEdit {
+	// Components are always set so the edit clears any the original response had
+	components := data.Components
+	if components == nil {
+		components = []discordgo.MessageComponent{}
+	}
+	edit := &discordgo.WebhookEdit{
+		Content:         &data.Content,
+		Components:      &components,
+		AllowedMentions: data.AllowedMentions,
+	}
+	if data.Embeds != nil {
+		edit.Embeds = &data.Embeds
+	}
+	return edit
+}
+
+// webhookParams converts response data into a follow-up message
+func webhookParams(data *discordgo.InteractionResponseData) *discordgo.WebhookParams {
+	return &discordgo.WebhookParams{
+		Content:         data.Content,
+		Components:      data.Components,
+		Embeds:          data.Embeds,
+		AllowedMentions: data.AllowedMentions,
+		Flags:           data.Flags,
+	}
+}
+
+// interactionUserID returns the ID of the user that triggered the interaction
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
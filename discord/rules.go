@@ -0,0 +1,121 @@
+package discord
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Rule triggers actions for the messages it matches
+type Rule struct {
+	Name     string
+	Match    Match
+	Actions  []RuleAction
+	Continue bool // Also evaluate the rules after this one when it matches
+}
+
+// RuleAction is an action triggered by a rule, with the parameters the rule gives it
+type RuleAction struct {
+	Name   string
+	Params map[string]string
+}
+
+// Match describes the messages a rule applies to. Unset conditions match every message.
+type Match struct {
+	ChannelIDs  []string       // Message is in one of these channels, or in a thread of one
+	RoleIDs     []string       // Author has at least one of these roles
+	Content     *regexp.Regexp // Message content matches the pattern
+	Attachments *bool          // Message has (true) or has no (false) attachments
+	Thread      *bool          // Message is (true) or is not (false) in a thread
+}
+
+// Rules are evaluated in order; the first rule matching a message applies, and the rules after
+// it are only evaluated if it continues
+type Rules []Rule
+
+// RuleMessage is a message rules are evaluated against
+type RuleMessage struct {
+	*discordgo.Message
+	Thread   bool   // Posted in a thread
+	ParentID string // Channel the thread belongs to
+}
+
+// newRuleMessage describes m for rule evaluation, looking up whether it was posted in a thread
+func newRuleMessage(s *discordgo.Session, m *discordgo.Message) RuleMessage {
+	msg := RuleMessage{Message: m}
+	if s == nil {
+		return msg
+	}
+	ch, err := s.State.Channel(m.ChannelID)
+	if err != nil {
+		if ch, err = s.Channel(m.ChannelID); err != nil {
+			return msg
+		}
+	}
+	if ch.IsThread() {
+		msg.Thread, msg.ParentID = true, ch.ParentID
+	}
+	return msg
+}
+
+// Evaluate returns the actions to take for msg, in order
+func (r Rules) Evaluate(msg RuleMessage) []RuleAction {
+	var actions []RuleAction
+	for _, rule := range r {
+		if !rule.Match.Matches(msg) {
+			continue
+		}
+		actions = append(actions, rule.Actions...)
+		if !rule.Continue {
+			break
+		}
+	}
+	return actions
+}
+
+// MayAddTracks reports whether a rule could add tracks from messages in the channel of msg,
+// considering only the channel and thread conditions
+func (r Rules) MayAddTracks(msg RuleMessage) bool {
+	for _, rule := range r {
+		if !rule.Match.matchesChannel(msg) {
+			continue
+		}
+		if slices.ContainsFunc(rule.Actions, func(a RuleAction) bool { return a.Name == ActionAddTracksToPlaylist }) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether msg meets every condition
+func (m Match) Matches(msg RuleMessage) bool {
+	if !m.matchesChannel(msg) {
+		return false
+	}
+	if len(m.RoleIDs) > 0 {
+		if msg.Member == nil || !slices.ContainsFunc(msg.Member.Roles, func(role string) bool {
+			return slices.Contains(m.RoleIDs, role)
+		}) {
+			return false
+		}
+	}
+	if m.Content != nil && !m.Content.MatchString(msg.Content) {
+		return false
+	}
+	if m.Attachments != nil && *m.Attachments != (len(msg.Attachments) > 0) {
+		return false
+	}
+	return true
+}
+
+// matchesChannel reports whether msg meets the channel and thread conditions
+func (m Match) matchesChannel(msg RuleMessage) bool {
+	if m.Thread != nil && *m.Thread != msg.Thread {
+		return false
+	}
+	if len(m.ChannelIDs) == 0 {
+		return true
+	}
+	return slices.Contains(m.ChannelIDs, msg.ChannelID) || (msg.Thread && slices.Contains(m.ChannelIDs, msg.ParentID))
+}
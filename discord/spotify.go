@@ -0,0 +1,140 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jdcukier/spotify/v2"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	spotifyclient "discordbot/spotify"
+	"discordbot/spotify/worker"
+	"discordbot/utils/ctxutil"
+)
+
+// spotifyAppsURL is where users revoke the access they granted to Spotify apps
+const spotifyAppsURL = "https://www.spotify.com/account/apps/"
+
+// SpotifyAccounts is an interface for managing the Spotify accounts users connect to the bot
+type SpotifyAccounts interface {
+	Account(ctx context.Context, userID string) (*spotify.PrivateUser, *worker.TokenData, error)
+	Connect(ctx context.Context, userID string) (bool, error)
+	Disconnect(ctx context.Context, userID string) error
+}
+
+// NewSpotifyCommand creates the /spotify slash command, which lets users connect, inspect and
+// disconnect the Spotify account tracks they submit are added with
+func NewSpotifyCommand(accounts SpotifyAccounts) *Command {
+	cmd := &spotifyCommand{accounts: accounts}
+	return &Command{
+		Name:        spotifyCommandName,
+		Description: "Manage your Spotify connection",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        spotifyConnectSubcommand,
+				Description: "Connect your Spotify account",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        spotifyStatusSubcommand,
+				Description: "Show which Spotify account is connected",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        spotifyDisconnectSubcommand,
+				Description: "Disconnect your Spotify account",
+			},
+		},
+		Handler: cmd.handle,
+		Defer:   true,
+		// The deferral must be ephemeral: the login link is sent as a follow-up, and whoever
+		// opens it connects their Spotify account to the user
+		Ephemeral: true,
+	}
+}
+
+// spotifyCommand holds the dependencies of the /spotify slash command
+type spotifyCommand struct {
+	accounts SpotifyAccounts
+}
+
+// handle runs the chosen subcommand
+func (c *spotifyCommand) handle(ctx context.Context, _ *discordgo.Session, i *discordgo.InteractionCreate) (*discordgo.InteractionResponse, error) {
+	options := i.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return nil, fmt.Errorf("no subcommand given")
+	}
+	subcommand := options[0].Name
+	userID := interactionUserID(i)
+	ctx, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.Action, subcommand))
+	logger.Info("Handling Spotify command", fields...)
+
+	switch subcommand {
+	case spotifyConnectSubcommand:
+		return c.connect(ctx, userID)
+	case spotifyStatusSubcommand:
+		return c.status(ctx, userID)
+	case spotifyDisconnectSubcommand:
+		return c.disconnect(ctx, userID)
+	default:
+		return nil, fmt.Errorf("unknown subcommand %q", subcommand)
+	}
+}
+
+// connect starts the OAuth flow; the login link is sent as a follow-up only the user can see
+func (c *spotifyCommand) connect(ctx context.Context, userID string) (*discordgo.InteractionResponse, error) {
+	started, err := c.accounts.Connect(ctx, userID)
+	switch {
+	case errors.Is(err, spotifyclient.ErrAlreadyConnected):
+		return ephemeralResponse("✅ Your Spotify account is already connected. " +
+			"Use `/spotify disconnect` first to connect a different one."), nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to connect Spotify: %w", err)
+	case !started:
+		return ephemeralResponse("⏳ You're already connecting Spotify — use the login link I sent you."), nil
+	default:
+		return ephemeralResponse("🎵 I'm sending you a private Spotify login link. It's only valid for a few minutes."), nil
+	}
+}
+
+// status describes the connected Spotify account and the token the bot holds for it
+func (c *spotifyCommand) status(ctx context.Context, userID string) (*discordgo.InteractionResponse, error) {
+	user, token, err := c.accounts.Account(ctx, userID)
+	if errors.Is(err, worker.ErrAuthRequired) {
+		return ephemeralResponse("🔌 You haven't connected Spotify. Use `/spotify connect` to connect it."), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up Spotify account: %w", err)
+	}
+
+	name := user.DisplayName
+	if name == "" {
+		name = user.ID
+	}
+	scopes := "none"
+	if fields := strings.Fields(token.Scope); len(fields) > 0 {
+		scopes = "`" + strings.Join(fields, "`, `") + "`"
+	}
+	return ephemeralResponse(fmt.Sprintf(
+		"🎧 Connected to Spotify as **%s** (`%s`)\nScopes: %s\nAccess token expires <t:%d:R> and is refreshed automatically",
+		name, user.ID, scopes, token.ExpiresAt)), nil
+}
+
+// disconnect deletes the token the bot holds for the user
+func (c *spotifyCommand) disconnect(ctx context.Context, userID string) (*discordgo.InteractionResponse, error) {
+	err := c.accounts.Disconnect(ctx, userID)
+	if errors.Is(err, worker.ErrAuthRequired) {
+		return ephemeralResponse("🔌 You haven't connected Spotify."), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to disconnect Spotify: %w", err)
+	}
+	return ephemeralResponse(fmt.Sprintf(
+		"🔌 Disconnected your Spotify account. Tracks you post won't be added until you `/spotify connect` again.\n"+
+			"To revoke the bot's access on Spotify's side too, remove it at <%s>.", spotifyAppsURL)), nil
+}
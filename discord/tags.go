@@ -0,0 +1,49 @@
+package discord
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/utils/ctxutil"
+)
+
+// tagRegex matches hashtags, e.g. #gym or #road-trip
+var tagRegex = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_-]+)`)
+
+// extractTags returns the lower-cased hashtags in content, without the #, in order of appearance
+func extractTags(content string) []string {
+	var tags []string
+	seen := make(map[string]struct{})
+	for _, match := range tagRegex.FindAllStringSubmatch(content, -1) {
+		tag := strings.ToLower(match[1])
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// tagPlaylists returns the playlists mapped to the hashtags in the message, which submissions
+// are also added to. Unknown tags are ignored.
+func (a *AddTracksToPlaylist) tagPlaylists(ctx context.Context) []string {
+	fields := ctxutil.ZapFields(ctx)
+
+	var playlistIDs []string
+	guild := a.guilds.Guild(a.event.GuildID)
+	for _, tag := range extractTags(a.event.Content) {
+		playlistID, ok := guild.PlaylistForTag(tag)
+		if !ok || slices.Contains(playlistIDs, playlistID) {
+			continue
+		}
+		logger.With(zap.String(zapkey.Tag, tag), zap.String(zapkey.PlaylistID, playlistID)).Info("Tagged for playlist", fields...)
+		playlistIDs = append(playlistIDs, playlistID)
+	}
+	return playlistIDs
+}
@@ -7,6 +7,7 @@ require (
 	github.com/jdcukier/spotify/v2 v2.5.0
 	github.com/joho/godotenv v1.5.1
 	go.uber.org/zap v1.27.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
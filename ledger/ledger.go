@@ -0,0 +1,204 @@
+// Package ledger provides a persistent record of the tracks the bot has added to playlists
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+)
+
+// Kind is the type of event a ledger entry records
+type Kind string
+
+const (
+	// KindAdded records a track added to a playlist by the bot
+	KindAdded Kind = "added"
+
+	// KindDuplicate records a submission of a track that was already in the playlist
+	KindDuplicate Kind = "duplicate"
+
+	// KindRemoved records a track removed from a playlist because its submission was withdrawn
+	KindRemoved Kind = "removed"
+)
+
+// Entry records a track submitted to a playlist on behalf of a Discord user
+type Entry struct {
+	Kind       Kind      `json:"kind,omitempty"` // Empty in entries written before kinds existed, meaning added
+	TrackID    string    `json:"track_id"`
+	PlaylistID string    `json:"playlist_id"`
+	SnapshotID string    `json:"snapshot_id"`          // Playlist snapshot returned by the add or remove
+	UserID     string    `json:"user_id"`              // Discord user who submitted the track
+	GuildID    string    `json:"guild_id,omitempty"`   // Guild the submission was made in
+	ChannelID  string    `json:"channel_id"`           // Channel the submission was made in
+	MessageID  string    `json:"message_id,omitempty"` // Empty for slash command submissions
+	AddedAt    time.Time `json:"added_at"`             // When the entry was recorded
+}
+
+// EventKind returns the kind of event the entry records
+func (e Entry) EventKind() Kind {
+	if e.Kind == "" {
+		return KindAdded
+	}
+	return e.Kind
+}
+
+// Ledger is an append-only, file-backed record of ledger entries.
+// The file holds one JSON entry per line and is loaded into memory on open.
+type Ledger struct {
+	mu      sync.RWMutex
+	file    *os.File
+	entries []Entry
+}
+
+// Open loads the ledger stored at path, creating it if it does not exist
+func Open(path string) (*Ledger, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no ledger path provided")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating ledger directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening ledger: %w", err)
+	}
+
+	l := &Ledger{file: file}
+	if err := l.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("loading ledger: %w", err)
+	}
+	logger.Info("Ledger loaded", zap.String(zapkey.Path, path), zap.Int(zapkey.Count, len(l.entries)))
+	return l, nil
+}
+
+// load reads all entries from the ledger file. Unreadable lines (e.g. a partial write
+// from a crash) are skipped so one bad line does not lose the rest of the history.
+func (l *Ledger) load() error {
+	scanner := bufio.NewScanner(l.file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Warn("Skipping unreadable ledger entry", zap.Error(err), zap.Int(zapkey.Line, line))
+			continue
+		}
+		l.entries = append(l.entries, entry)
+	}
+	return scanner.Err()
+}
+
+// Record appends entries to the ledger
+func (l *Ledger) Record(entries ...Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var buf []byte
+	for _, entry := range entries {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("encoding ledger entry: %w", err)
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(buf); err != nil {
+		return fmt.Errorf("writing ledger entry: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("syncing ledger: %w", err)
+	}
+	l.entries = append(l.entries, entries...)
+	return nil
+}
+
+// Close closes the ledger file
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// --- Queries ---
+
+// Query returns the entries matching filter, oldest first
+func (l *Ledger) Query(filter func(Entry) bool) []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var matches []Entry
+	for _, entry := range l.entries {
+		if filter == nil || filter(entry) {
+			matches = append(matches, entry)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].AddedAt.Before(matches[j].AddedAt) })
+	return matches
+}
+
+// Entries returns every entry in the ledger, oldest first
+func (l *Ledger) Entries() []Entry {
+	return l.Query(nil)
+}
+
+// Find returns the entry recording when a track currently in a playlist was added by the bot.
+// Additions undone by a later removal are ignored.
+func (l *Ledger) Find(playlistID, trackID string) (Entry, bool) {
+	var current *Entry
+	for _, entry := range l.Track(playlistID, trackID) {
+		switch entry.EventKind() {
+		case KindAdded:
+			if current == nil {
+				current = &entry
+			}
+		case KindRemoved:
+			current = nil
+		}
+	}
+	if current == nil {
+		return Entry{}, false
+	}
+	return *current, true
+}
+
+// Track returns every entry for a track in a playlist
+func (l *Ledger) Track(playlistID, trackID string) []Entry {
+	return l.Query(func(e Entry) bool {
+		return e.PlaylistID == playlistID && e.TrackID == trackID
+	})
+}
+
+// ByUser returns the entries for tracks submitted by a Discord user
+func (l *Ledger) ByUser(userID string) []Entry {
+	return l.Query(func(e Entry) bool { return e.UserID == userID })
+}
+
+// ByPlaylist returns the entries for tracks added to a playlist
+func (l *Ledger) ByPlaylist(playlistID string) []Entry {
+	return l.Query(func(e Entry) bool { return e.PlaylistID == playlistID })
+}
+
+// ByMessage returns the entries for tracks submitted in a Discord message
+func (l *Ledger) ByMessage(messageID string) []Entry {
+	return l.Query(func(e Entry) bool { return e.MessageID == messageID })
+}
+
+// Since returns the entries added at or after t
+func (l *Ledger) Since(t time.Time) []Entry {
+	return l.Query(func(e Entry) bool { return !e.AddedAt.Before(t) })
+}
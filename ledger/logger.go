@@ -0,0 +1,7 @@
+package ledger
+
+import (
+	"discordbot/log"
+)
+
+var logger = log.Logger.Named("ledger")
@@ -0,0 +1,58 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jdcukier/spotify/v2"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/worker"
+)
+
+// ErrAlreadyConnected is returned when connecting a user whose Spotify account is already connected
+var ErrAlreadyConnected = errors.New("spotify account already connected")
+
+// --- Accounts ---
+
+// Account returns the Spotify account the Discord user has connected, along with the token the
+// bot holds for it. Returns worker.ErrAuthRequired if they have not connected one.
+func (c *Client) Account(ctx context.Context, userID string) (*spotify.PrivateUser, *worker.TokenData, error) {
+	token, err := c.tokens.get(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := c.spotifyClientForUser(userID).CurrentUser(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching current user: %w", err)
+	}
+	return user, token, nil
+}
+
+// Connect starts the OAuth flow for the Discord user, delivering the login link to them. Returns
+// false if a flow was already in progress for them, and ErrAlreadyConnected if they have
+// connected an account already.
+func (c *Client) Connect(ctx context.Context, userID string) (bool, error) {
+	_, err := c.tokens.get(ctx, userID)
+	if err == nil {
+		return false, ErrAlreadyConnected
+	}
+	if !errors.Is(err, worker.ErrAuthRequired) {
+		return false, err
+	}
+	return c.triggerAuthIfNeeded(ctx, userID, "", nil), nil
+}
+
+// Disconnect deletes the token the bot holds for the Discord user, so it can no longer act on
+// their Spotify account. Returns worker.ErrAuthRequired if they have not connected one.
+func (c *Client) Disconnect(ctx context.Context, userID string) error {
+	// Drop the cached token even if the worker has none, so it is not used again
+	defer c.tokens.invalidate(userID)
+	if err := c.workerClient.DeleteToken(ctx, userID); err != nil {
+		return fmt.Errorf("deleting token from worker: %w", err)
+	}
+	logger.Info("Disconnected Spotify account", zap.String(zapkey.UserID, userID))
+	return nil
+}
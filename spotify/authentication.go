@@ -9,7 +9,6 @@ import (
 	"go.uber.org/zap"
 
 	"discordbot/constants/zapkey"
-	"discordbot/discord/channel"
 	"discordbot/spotify/worker"
 )
 
@@ -18,22 +17,35 @@ const (
 	authPollTimeout  = 3 * time.Minute
 )
 
-func (c *Client) authenticate(ctx context.Context, userID string) error {
+// ErrDMsClosed is returned when a login link had to be sent by DM and the user does not accept DMs
+var ErrDMsClosed = errors.New("user does not accept direct messages")
+
+// errAuthLinkNotSent is the error for auth flows whose login link could not be delivered
+var errAuthLinkNotSent = errors.New("login link not delivered")
+
+func (c *Client) authenticate(ctx context.Context, userID string, entry *pendingEntry) error {
 	authURL, err := c.workerClient.GetAuthURL(ctx, userID)
 	if err != nil {
-		return fmt.Errorf("fetching auth URL from worker: %w", err)
+		return fmt.Errorf("%w: fetching auth URL from worker: %w", errAuthLinkNotSent, err)
 	}
 
-	message := fmt.Sprintf(
-		"🎵 <@%s> Spotify authentication required. Open this URL to connect:\n%s", userID, authURL)
-	if c.messenger != nil {
-		if err := c.messenger.SendMessage(ctx, channel.Auth.String(), message); err != nil {
-			logger.Error("Failed to post auth URL to Discord; user must re-trigger auth",
-				zap.Error(err), zap.String(zapkey.UserID, userID))
-		}
-	} else {
-		logger.Warn("Messenger not configured; auth URL cannot be delivered to user",
-			zap.String(zapkey.UserID, userID))
+	// The URL binds whoever opens it to the user, so it must only be shown to them
+	if c.messenger == nil {
+		return fmt.Errorf("%w: messenger not configured", errAuthLinkNotSent)
+	}
+	if err := c.messenger.SendAuthLink(ctx, userID, authURL); err != nil {
+		// Nobody can complete the flow, so there is no point waiting for a token
+		return fmt.Errorf("%w: %w", errAuthLinkNotSent, err)
+	}
+
+	// Tell the user about the link only now that they have it
+	c.authMu.Lock()
+	entry.linkSent = true
+	notices := entry.notices
+	entry.notices = nil
+	c.authMu.Unlock()
+	for _, notice := range notices {
+		c.reportToDiscord(ctx, notice)
 	}
 
 	return c.pollForToken(ctx, userID)
@@ -0,0 +1,313 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jdcukier/spotify/v2"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/ledger"
+	"discordbot/spotify/track"
+	"discordbot/spotify/worker"
+	"discordbot/utils/ctxutil"
+)
+
+// batchFlushTimeout bounds a batched playlist write, which outlives the requests waiting on it
+const batchFlushTimeout = 30 * time.Second
+
+// errTrackNotAdded is the error for tracks that Spotify did not add although the write succeeded
+var errTrackNotAdded = errors.New("track missing from playlist after add")
+
+// batchRequest is a submission's new tracks waiting to be written to a playlist
+type batchRequest struct {
+	ctx      context.Context
+	api      *spotify.Client // Session of the submitter; their tracks are written with it if the batch's session needs auth
+	sub      track.Submission
+	trackIDs []spotify.ID
+	done     chan batchResult
+}
+
+// batchResult is the outcome of a batched write for one request
+type batchResult struct {
+	added      []spotify.ID // Tracks the request added
+	duplicates []spotify.ID // Tracks added by an earlier request in the batch, or since the request checked
+	err        error
+}
+
+// playlistBatch collects the requests for a playlist until they are written
+type playlistBatch struct {
+	requests []*batchRequest
+	count    int // Tracks across requests, including repeats
+	timer    *time.Timer
+}
+
+// batcher coalesces bursts of submissions to a playlist into as few writes as possible. Requests
+// are collected for a short window, or until a write's worth of tracks is waiting, then written
+// with one add call made with the session of the first submitter. The ledger credits every track
+// to the user who submitted it. If the first submitter's session needs auth, the other
+// submitters' tracks are written with their own sessions, so one user's expired session does not
+// fail anyone else's submissions.
+//
+// Writes to a playlist happen one at a time, and each checks the playlist again before adding, so
+// submissions racing each other cannot add a track twice. Anything else changing a playlist's
+// tracks must hold the playlist's lock too.
+type batcher struct {
+	client *Client
+	window time.Duration // 0 writes every request on its own
+
+	mu      sync.Mutex
+	batches map[string]*playlistBatch // Collecting batches, by playlist ID
+	writing map[string]*sync.Mutex    // Serializes the writes to each playlist, by playlist ID
+}
+
+// newBatcher creates a batcher writing to playlists through client
+func newBatcher(client *Client, window time.Duration) *batcher {
+	return &batcher{
+		client:  client,
+		window:  window,
+		batches: make(map[string]*playlistBatch),
+		writing: make(map[string]*sync.Mutex),
+	}
+}
+
+// add queues the tracks of sub for writing to its playlist and waits for the write. If ctx is
+// done before the write starts, the tracks are dropped from it and ctx's error returned.
+func (b *batcher) add(ctx context.Context, api *spotify.Client, sub track.Submission, trackIDs []spotify.ID) batchResult {
+	req := &batchRequest{ctx: ctx, api: api, sub: sub, trackIDs: trackIDs, done: make(chan batchResult, 1)}
+	playlistID := sub.PlaylistID
+
+	b.mu.Lock()
+	if b.window <= 0 {
+		b.mu.Unlock()
+		b.flush(playlistID, []*batchRequest{req})
+		return <-req.done
+	}
+	batch, ok := b.batches[playlistID]
+	if !ok {
+		batch = &playlistBatch{}
+		batch.timer = time.AfterFunc(b.window, func() { b.flushBatch(playlistID, batch) })
+		b.batches[playlistID] = batch
+	}
+	batch.requests = append(batch.requests, req)
+	batch.count += len(trackIDs)
+	full := batch.count >= maxTracksPerRequest
+	b.mu.Unlock()
+
+	if full {
+		go b.flushBatch(playlistID, batch)
+	}
+
+	select {
+	case result := <-req.done:
+		return result
+	case <-ctx.Done():
+	}
+	if b.withdraw(playlistID, req) {
+		return batchResult{err: ctx.Err()}
+	}
+	// The write has started, so its outcome is what happened to the tracks
+	return <-req.done
+}
+
+// withdraw drops req from the batch collecting for a playlist. Returns false if req is no longer
+// waiting there, i.e. its batch is being written.
+func (b *batcher) withdraw(playlistID string, req *batchRequest) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.batches[playlistID]
+	if !ok {
+		return false
+	}
+	i := slices.Index(batch.requests, req)
+	if i < 0 {
+		return false
+	}
+	batch.requests = slices.Delete(batch.requests, i, i+1)
+	batch.count -= len(req.trackIDs)
+	if len(batch.requests) == 0 {
+		batch.timer.Stop()
+		delete(b.batches, playlistID)
+	}
+	return true
+}
+
+// flushBatch writes batch unless it was already written
+func (b *batcher) flushBatch(playlistID string, batch *playlistBatch) {
+	b.mu.Lock()
+	if b.batches[playlistID] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, playlistID)
+	batch.timer.Stop()
+	b.mu.Unlock()
+	b.flush(playlistID, batch.requests)
+}
+
+// flush writes the tracks of requests to a playlist, records who submitted each one, and hands
+// every request its outcome. Tracks submitted by several requests are credited to the first.
+func (b *batcher) flush(playlistID string, requests []*batchRequest) {
+	defer b.lock(playlistID)()
+
+	// Requests whose submitter stopped waiting while the playlist was locked, e.g. because the
+	// action timed out, are not written
+	var live []*batchRequest
+	for _, req := range requests {
+		if err := req.ctx.Err(); err != nil {
+			req.done <- batchResult{err: err}
+			continue
+		}
+		live = append(live, req)
+	}
+	if len(live) == 0 {
+		return
+	}
+	requests = live
+
+	leader := requests[0]
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(leader.ctx), batchFlushTimeout)
+	defer cancel()
+	fields := ctxutil.ZapFields(ctx)
+
+	// The requests checked the playlist before waiting for the lock, so check it again. This is
+	// usually a cache hit costing one snapshot lookup. If even that fails, the last known tracks
+	// still cover everything the bot has written.
+	existing, checkErr := b.client.existingTrackIDs(ctx, leader.api, playlistID)
+	if checkErr != nil {
+		logger.With(zap.Error(checkErr)).Warn("Cannot recheck playlist tracks; using cached tracks", fields...)
+		existing, _ = b.client.playlists.Tracks(playlistID)
+	}
+
+	// Dedup across the batch, crediting each track to the first request submitting it
+	owners := make(map[spotify.ID]*batchRequest)
+	var trackIDs []spotify.ID
+	for _, req := range requests {
+		for _, trackID := range req.trackIDs {
+			if _, ok := existing[trackID]; ok {
+				continue
+			}
+			if _, ok := owners[trackID]; !ok {
+				owners[trackID] = req
+				trackIDs = append(trackIDs, trackID)
+			}
+		}
+	}
+	if len(requests) > 1 {
+		logger.With(zap.Int(zapkey.Count, len(trackIDs)), zap.Int(zapkey.Requests, len(requests))).
+			Info("Writing batched tracks", fields...)
+	}
+
+	var added []spotify.ID
+	var snapshotID string
+	write := func(api *spotify.Client, trackIDs []spotify.ID) error {
+		written, snapshot, err := b.client.addTracks(ctx, api, playlistID, trackIDs)
+		if len(written) > 0 {
+			if checkErr != nil {
+				// The cached tracks may be stale, and must not be carried to the new snapshot
+				b.client.playlists.Invalidate(playlistID)
+			} else {
+				b.client.playlists.Apply(playlistID, snapshot, written, nil)
+			}
+			added = append(added, written...)
+			snapshotID = snapshot
+		}
+		return err
+	}
+
+	// Write every new track in one call with the first submitter's session
+	failures := make(map[string]error) // Why a user's tracks were not added, by user ID
+	var err error
+	if len(trackIDs) > 0 {
+		err = write(leader.api, trackIDs)
+	}
+	if errors.Is(err, worker.ErrAuthRequired) {
+		// Only the first submitter needs to connect Spotify again; write the tracks of everyone
+		// else with their own session
+		failures[leader.sub.UserID] = err
+		err = nil
+		for _, userRequests := range requestsByUser(requests) {
+			req := userRequests[0]
+			if req.sub.UserID == leader.sub.UserID {
+				continue
+			}
+			var remaining []spotify.ID
+			for _, trackID := range trackIDs {
+				if slices.Contains(userRequests, owners[trackID]) && !slices.Contains(added, trackID) {
+					remaining = append(remaining, trackID)
+				}
+			}
+			if len(remaining) == 0 {
+				continue
+			}
+			if userErr := write(req.api, remaining); userErr != nil {
+				failures[req.sub.UserID] = userErr
+			}
+		}
+	}
+	addedSet := make(map[spotify.ID]struct{}, len(added))
+	for _, trackID := range added {
+		addedSet[trackID] = struct{}{}
+	}
+
+	// Record additions before handing out results, so that requests told their tracks were
+	// already added can find who added them
+	results := make([]batchResult, len(requests))
+	for i, req := range requests {
+		for _, trackID := range req.trackIDs {
+			_, ok := addedSet[trackID]
+			_, prior := existing[trackID]
+			switch {
+			case ok && owners[trackID] == req:
+				results[i].added = append(results[i].added, trackID)
+			case ok || prior:
+				results[i].duplicates = append(results[i].duplicates, trackID)
+			case failures[owners[trackID].sub.UserID] != nil:
+				results[i].err = failures[owners[trackID].sub.UserID]
+			case err != nil:
+				results[i].err = err
+			default:
+				// Never report a track that is not in the playlist as a success
+				results[i].err = errTrackNotAdded
+			}
+		}
+		b.client.record(req.ctx, req.sub, ledger.KindAdded, snapshotID, results[i].added)
+	}
+	for i, req := range requests {
+		req.done <- results[i]
+	}
+}
+
+// requestsByUser groups requests by the user who submitted them, in order of first submission
+func requestsByUser(requests []*batchRequest) [][]*batchRequest {
+	var groups [][]*batchRequest
+	index := make(map[string]int)
+	for _, req := range requests {
+		i, ok := index[req.sub.UserID]
+		if !ok {
+			i = len(groups)
+			index[req.sub.UserID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], req)
+	}
+	return groups
+}
+
+// lock takes the lock serializing changes to a playlist and returns the function releasing it
+func (b *batcher) lock(playlistID string) (unlock func()) {
+	b.mu.Lock()
+	lock, ok := b.writing[playlistID]
+	if !ok {
+		lock = &sync.Mutex{}
+		b.writing[playlistID] = lock
+	}
+	b.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
@@ -6,27 +6,50 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/jdcukier/spotify/v2"
 	"go.uber.org/zap"
 
 	"discordbot/constants/zapkey"
 	"discordbot/discord/channel"
+	"discordbot/ledger"
 	"discordbot/spotify/config"
+	"discordbot/spotify/convert"
+	"discordbot/spotify/playlistcache"
+	"discordbot/spotify/retryqueue"
+	"discordbot/spotify/track"
 	"discordbot/spotify/worker"
 )
 
+const (
+	// shortLinkTimeout bounds how long resolving a short link may take
+	shortLinkTimeout = 5 * time.Second
+
+	// metadataTimeout bounds how long looking up a song linked on another platform may take
+	metadataTimeout = 10 * time.Second
+)
+
 // MessageSender is an interface for posting messages
-// This will primarily be used for posting the Spotify Auth link to the user instead of
-// needing to check the logs to find it.
-// Note: This may be expanded to support other message posting in the future.
+// This will primarily be used for delivering the Spotify Auth link to the user instead of
+// needing to check the logs to find it, and for reporting the outcome of submissions
+// that complete after the original message was handled.
 type MessageSender interface {
 	SendMessage(ctx context.Context, channelType string, message string) error
+	// SendAuthLink delivers a user's Spotify login link so only they can see it. Returns an error
+	// wrapping ErrDMsClosed if the link had to go by DM and the user does not accept DMs.
+	SendAuthLink(ctx context.Context, userID string, authURL string) error
+	UpdateSubmission(ctx context.Context, sub track.Submission, results []track.Result) error
 }
 
+// authCallback is run once an auth flow finishes. err is nil if auth succeeded.
+type authCallback func(ctx context.Context, err error)
+
 // pendingEntry holds queued post-auth callbacks for one user.
 type pendingEntry struct {
-	callbacks []func(context.Context)
+	callbacks []authCallback
+	linkSent  bool     // The login link was delivered
+	notices   []string // Posted once the login link is delivered
 }
 
 // Client represents a spotify client
@@ -40,6 +63,30 @@ type Client struct {
 	// Cloudflare Worker client
 	workerClient *worker.Client
 
+	// Spotify access tokens of users, fetched from the worker
+	tokens *tokenCache
+
+	// Record of tracks added by the bot (optional)
+	ledger *ledger.Ledger
+
+	// Resolves spotify.link and spoti.fi short links
+	resolver *track.Resolver
+
+	// Converts song links from other platforms; nil if no metadata provider is configured
+	converter *convert.Converter
+
+	// Tracks in each playlist, so duplicates can be found without paging through playlists
+	playlists *playlistcache.Cache
+
+	// Coalesces submissions to the same playlist into batched writes
+	batcher *batcher
+
+	// Holds back Spotify requests while the app is rate limited
+	rateLimit *rateLimiter
+
+	// Retries submissions waiting for auth or after transient failures
+	retrier *retrier
+
 	// Per-user auth tracking. authMu protects authenticatingUsers and each entry's callbacks.
 	authMu              sync.Mutex
 	authenticatingUsers map[string]*pendingEntry
@@ -72,8 +119,28 @@ func NewClient(opts ...Option) (*Client, error) {
 		c.config.WorkerURL,
 		c.config.CFAccessClientID,
 		c.config.CFAccessClientSecret,
+		worker.WithTimeout(c.config.WorkerTimeout),
+		worker.WithRetries(c.config.WorkerRetries),
+		worker.WithBreaker(c.config.WorkerBreakerThreshold, c.config.WorkerBreakerCooldown),
 	)
+	c.tokens = newTokenCache(c.workerClient)
 	c.authenticatingUsers = make(map[string]*pendingEntry)
+	c.resolver = track.NewResolver(shortLinkTimeout)
+	if c.config.MetadataProviderURL != "" {
+		c.converter = convert.NewConverter(convert.NewHTTPProvider(c.config.MetadataProviderURL, metadataTimeout))
+	}
+	playlists, err := playlistcache.New(c.config.PlaylistCachePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create playlist cache: %w", err)
+	}
+	c.playlists = playlists
+	c.batcher = newBatcher(c, c.config.BatchWindow)
+	c.rateLimit = newRateLimiter()
+	retries, err := retryqueue.Open(c.config.RetryQueuePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open retry queue: %w", err)
+	}
+	c.retrier = newRetrier(c, retries, c.config.RetryTTL)
 
 	return c, nil
 }
@@ -86,11 +153,13 @@ func (c *Client) String() string {
 // -- Start/Stop ---
 
 func (c *Client) Start() error {
+	c.retrier.start()
 	logger.Info("Spotify client started; auth triggers on first song request per user")
 	return nil
 }
 
 func (c *Client) Stop() error {
+	c.retrier.stop()
 	return nil
 }
 
@@ -100,53 +169,75 @@ func (c *Client) SetMessenger(messenger MessageSender) {
 }
 
 // spotifyClientForUser creates a per-call Spotify SDK client for the given Discord user.
+// The user's token is shared with every other client made for them.
 func (c *Client) spotifyClientForUser(userID string) *spotify.Client {
 	t := &workerTransport{
-		workerClient: c.workerClient,
-		userID:       userID,
-		base:         http.DefaultTransport,
+		tokens:  c.tokens,
+		userID:  userID,
+		base:    http.DefaultTransport,
+		limiter: c.rateLimit,
 	}
 	return spotify.New(&http.Client{Transport: t})
 }
 
 // triggerAuthIfNeeded starts the OAuth flow in a background goroutine for the given user.
-// If an auth flow is already running for this user, onSuccess is queued and will be called
-// after auth completes. If auth fails, all queued callbacks are discarded and the user is
-// notified to post their track again.
-func (c *Client) triggerAuthIfNeeded(ctx context.Context, userID string, onSuccess func(context.Context)) {
+// notice, if set, is posted once the user's login link has been delivered, so users are never
+// told about a link they did not get. If an auth flow is already running for this user, onDone
+// is queued and will be called after auth completes. If auth fails, all queued callbacks are called with the error so
+// they can report the failure, and the user is notified to post a track again to retry.
+// Submissions queued for the user are retried once auth succeeds, by this flow or a later one.
+// Returns true if a new flow was started.
+func (c *Client) triggerAuthIfNeeded(ctx context.Context, userID string, notice string, onDone authCallback) bool {
 	c.authMu.Lock()
 	entry, exists := c.authenticatingUsers[userID]
 	if exists {
-		if onSuccess != nil {
-			entry.callbacks = append(entry.callbacks, onSuccess)
+		if onDone != nil {
+			entry.callbacks = append(entry.callbacks, onDone)
+		}
+		postNow := notice != "" && entry.linkSent
+		if notice != "" && !entry.linkSent {
+			entry.notices = append(entry.notices, notice)
 		}
 		c.authMu.Unlock()
 		logger.Info("OAuth flow already in progress for user, queuing callback",
 			zap.String(zapkey.UserID, userID))
-		return
+		if postNow {
+			c.reportToDiscord(ctx, notice)
+		}
+		return false
 	}
 	entry = &pendingEntry{}
-	if onSuccess != nil {
-		entry.callbacks = []func(context.Context){onSuccess}
+	if onDone != nil {
+		entry.callbacks = []authCallback{onDone}
+	}
+	if notice != "" {
+		entry.notices = []string{notice}
 	}
 	c.authenticatingUsers[userID] = entry
 	c.authMu.Unlock()
 
+	// The cached token is no good if auth is needed, and is replaced once auth completes
+	c.tokens.invalidate(userID)
+
 	go func() {
-		authCtx := context.Background()
-		if err := c.authenticate(authCtx, userID); err != nil {
-			// Drain callbacks atomically with the map delete. We intentionally do not
-			// call them: the original requests are no longer retryable (auth failed),
-			// and calling them would re-enter the auth flow.
+		// Keep the context's values (e.g. the guild to report to) but not its deadline,
+		// since the flow outlives the request that triggered it
+		authCtx := context.WithoutCancel(ctx)
+		if err := c.authenticate(authCtx, userID, entry); err != nil {
+			// Drain callbacks atomically with the map delete. Callbacks receive the error so
+			// they can report the failure; they must not retry, since the original requests
+			// are no longer retryable (auth failed) and retrying would re-enter the auth flow.
 			c.authMu.Lock()
 			delete(c.authenticatingUsers, userID)
+			callbacks := entry.callbacks
 			entry.callbacks = nil
 			c.authMu.Unlock()
 
 			logger.Error("Spotify OAuth flow failed", zap.Error(err), zap.String(zapkey.UserID, userID))
-			c.reportToDiscord(authCtx, fmt.Sprintf(
-				"❌ <@%s> Spotify authentication failed: %v\n"+
-					"Post a track again to retry.", userID, err))
+			c.reportToDiscord(authCtx, authFailureMessage(err, userID))
+			for _, cb := range callbacks {
+				cb(authCtx, err)
+			}
 			return
 		}
 
@@ -158,16 +249,34 @@ func (c *Client) triggerAuthIfNeeded(ctx context.Context, userID string, onSucce
 		entry.callbacks = nil
 		c.authMu.Unlock()
 
+		c.tokens.invalidate(userID)
 		logger.Info("Spotify OAuth flow completed successfully", zap.String(zapkey.UserID, userID))
-		if len(callbacks) == 0 {
+		resumed := c.retrier.resume(userID)
+		if len(callbacks) == 0 && resumed == 0 {
 			// Feedback for when the user has authenticated without any songs pending
 			c.reportToDiscord(authCtx, fmt.Sprintf("✅ <@%s> Spotify connected successfully!", userID))
 		} else {
 			for _, cb := range callbacks {
-				cb(authCtx)
+				cb(authCtx, nil)
 			}
 		}
 	}()
+	return true
+}
+
+// authFailureMessage tells a user why their Spotify auth flow failed
+func authFailureMessage(err error, userID string) string {
+	switch {
+	case errors.Is(err, ErrDMsClosed):
+		return fmt.Sprintf("🔒 <@%s> I couldn't DM you your Spotify login link. "+
+			"Allow direct messages from this server's members, then post a track again and check your DMs.", userID)
+	case errors.Is(err, errAuthLinkNotSent):
+		return fmt.Sprintf("❌ <@%s> I couldn't send you a Spotify login link: %v\n"+
+			"Post a track again to retry; tracks you already posted are added once you connect.", userID, err)
+	default:
+		return fmt.Sprintf("❌ <@%s> Spotify authentication failed: %v\n"+
+			"Post a track again to retry; tracks you already posted are added once you connect.", userID, err)
+	}
 }
 
 // handleSpotifyError reports errors to Discord and re-triggers auth if needed.
@@ -178,15 +287,49 @@ func (c *Client) handleSpotifyError(ctx context.Context, err error, operation st
 	if errors.Is(err, worker.ErrAuthRequired) {
 		logger.Warn("Spotify auth required; triggering re-authentication",
 			zap.Error(err), zap.String(zapkey.UserID, userID))
-		c.reportToDiscord(ctx, fmt.Sprintf(
+		c.triggerAuthIfNeeded(ctx, userID, fmt.Sprintf(
 			"⚠️ <@%s> Spotify session expired or not connected. Re-authentication started — "+
-				"check this channel for the login link.", userID))
-		c.triggerAuthIfNeeded(ctx, userID, nil)
+				"I've sent you a private login link.", userID), nil)
 		return
 	}
 	logger.Error("Spotify operation failed",
-		zap.String("operation", operation), zap.Error(err), zap.String(zapkey.UserID, userID))
-	c.reportToDiscord(ctx, fmt.Sprintf("❌ <@%s> Spotify error (%s): %v", userID, operation, err))
+		zap.String(zapkey.Operation, operation), zap.Error(err), zap.String(zapkey.UserID, userID))
+	c.reportToDiscord(ctx, errorMessage(err, operation, userID))
+}
+
+// errorMessage describes a failed Spotify operation to the user it failed for, telling them
+// whether to wait or get an admin to fix something
+func errorMessage(err error, operation string, userID string) string {
+	switch {
+	case errors.Is(err, worker.ErrUnavailable):
+		return fmt.Sprintf("⚠️ <@%s> The Spotify connection service is down right now. Try again in a few minutes.", userID)
+	case errors.Is(err, worker.ErrRateLimited), errors.Is(err, ErrRateLimited):
+		return fmt.Sprintf("⏳ <@%s> Spotify is getting too many requests right now. Try again in a minute.", userID)
+	case errors.Is(err, worker.ErrForbidden):
+		return fmt.Sprintf("❌ <@%s> The bot was refused access to the Spotify connection service. "+
+			"An admin needs to check its Cloudflare Access credentials.", userID)
+	case errors.Is(err, worker.ErrMisconfigured):
+		return fmt.Sprintf("❌ <@%s> The Spotify connection service is misconfigured. "+
+			"An admin needs to check the worker URL.", userID)
+	default:
+		return fmt.Sprintf("❌ <@%s> Spotify error (%s): %v", userID, operation, err)
+	}
+}
+
+// Healthy reports whether the Spotify worker is taking calls
+func (c *Client) Healthy() bool {
+	return c.workerClient.Healthy()
+}
+
+// updateSubmission reports the outcome of a submission that completed asynchronously.
+func (c *Client) updateSubmission(ctx context.Context, sub track.Submission, results []track.Result) {
+	if c.messenger == nil {
+		return
+	}
+	if err := c.messenger.UpdateSubmission(ctx, sub, results); err != nil {
+		logger.Warn("Failed to report submission outcome to Discord", zap.Error(err),
+			zap.String(zapkey.UserID, sub.UserID), zap.String(zapkey.MessageID, sub.MessageID))
+	}
 }
 
 func (c *Client) reportToDiscord(ctx context.Context, message string) {
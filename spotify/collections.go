@@ -0,0 +1,207 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jdcukier/spotify/v2"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/convert"
+	"discordbot/spotify/track"
+	"discordbot/spotify/worker"
+	"discordbot/utils/ctxutil"
+)
+
+const (
+	// albumPageSize is the most tracks Spotify returns per page of an album
+	albumPageSize = 50
+
+	// playlistPageSize is the most items Spotify returns per page of a playlist
+	playlistPageSize = 100
+)
+
+// linkGroup is the set of tracks submitted through a single link
+type linkGroup struct {
+	link       string
+	trackIDs   []spotify.ID
+	collection bool  // Album or playlist link, which may need confirmation
+	err        error // Set if the link could not be resolved to any tracks
+
+	// For links converted from another platform
+	source         string
+	sourcePlatform string
+	sourceSong     string
+	match          string
+	confidence     float64
+	uncertain      bool // The match is not confident enough to add without confirmation
+}
+
+// errUnsupportedLink is the error for Spotify links that do not lead to tracks
+var errUnsupportedLink = errors.New("unsupported link")
+
+// expandLinks resolves each submitted link into the tracks it refers to.
+// Short links are resolved first; album and playlist links are expanded up to the configured limits.
+// Links that cannot be resolved, e.g. private or deleted playlists, fail on their own. Errors that
+// affect every link, such as missing auth or a Spotify outage, fail the whole submission.
+func (c *Client) expandLinks(ctx context.Context, api *spotify.Client, urls []string) ([]linkGroup, error) {
+	var groups []linkGroup
+	for _, url := range urls {
+		if _, ok := convert.DetectPlatform(url); ok {
+			groups = append(groups, c.convertLink(ctx, api, url))
+			continue
+		}
+		url, err := c.resolveLink(ctx, url)
+		if err != nil {
+			logger.With(zap.Error(err), zap.String(zapkey.URL, url)).Warn("Cannot resolve short link", ctxutil.ZapFields(ctx)...)
+			groups = append(groups, linkGroup{link: url, err: err})
+			continue
+		}
+		if trackID := track.ExtractTrackID(url); trackID != "" {
+			groups = append(groups, linkGroup{link: url, trackIDs: []spotify.ID{spotify.ID(trackID)}})
+			continue
+		}
+		if albumID := track.ExtractAlbumID(url); albumID != "" {
+			trackIDs, err := c.albumTrackIDs(ctx, api, albumID, c.config.MaxAlbumTracks)
+			if err != nil {
+				err = fmt.Errorf("cannot access album %s: %w", albumID, err)
+				if !linkError(ctx, err) {
+					return nil, err
+				}
+				logger.With(zap.Error(err), zap.String(zapkey.URL, url)).Warn("Cannot expand album link", ctxutil.ZapFields(ctx)...)
+			}
+			groups = append(groups, linkGroup{link: url, trackIDs: trackIDs, collection: true, err: err})
+			continue
+		}
+		if playlistID := track.ExtractPlaylistID(url); playlistID != "" {
+			trackIDs, err := c.playlistTrackIDs(ctx, api, playlistID, c.config.MaxPlaylistTracks)
+			if err != nil {
+				err = fmt.Errorf("cannot access playlist %s: %w", playlistID, err)
+				if !linkError(ctx, err) {
+					return nil, err
+				}
+				logger.With(zap.Error(err), zap.String(zapkey.URL, url)).Warn("Cannot expand playlist link", ctxutil.ZapFields(ctx)...)
+			}
+			groups = append(groups, linkGroup{link: url, trackIDs: trackIDs, collection: true, err: err})
+			continue
+		}
+
+		// Episode and artist links, including short links leading to them, have no tracks to add
+		kind := "unknown"
+		if link, ok := track.ParseLink(url); ok {
+			kind = string(link.Kind)
+		}
+		err = fmt.Errorf("%w: %s links cannot be added to playlists", errUnsupportedLink, kind)
+		logger.With(zap.Error(err), zap.String(zapkey.URL, url)).Info("Skipping unsupported link", ctxutil.ZapFields(ctx)...)
+		groups = append(groups, linkGroup{link: url, err: err})
+	}
+	return groups, nil
+}
+
+// linkError reports whether err expanding a link concerns only that link, e.g. because Spotify
+// has no such album. Missing auth, cancellation and trouble that may pass concern every link.
+func linkError(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, worker.ErrAuthRequired) && !transient(err)
+}
+
+// convertLink finds the Spotify track matching a song link from another platform
+func (c *Client) convertLink(ctx context.Context, api *spotify.Client, url string) linkGroup {
+	_, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.URL, url))
+	if c.converter == nil {
+		err := fmt.Errorf("no metadata provider configured")
+		logger.With(zap.Error(err)).Warn("Cannot convert link", fields...)
+		return linkGroup{link: url, err: err}
+	}
+
+	match, err := c.converter.Convert(ctx, url, func(ctx context.Context, query string, limit int) ([]track.Summary, error) {
+		return searchTracks(ctx, api, query, limit)
+	})
+	if err != nil {
+		logger.With(zap.Error(err)).Warn("Cannot convert link", fields...)
+		return linkGroup{link: url, err: err}
+	}
+
+	logger.With(
+		zap.String(zapkey.TrackID, string(match.Track.ID)),
+		zap.Float64(zapkey.Confidence, match.Confidence),
+	).Info("Converted link to Spotify track", fields...)
+	platform := match.Metadata.Platform
+	if platform == "" {
+		platform, _ = convert.DetectPlatform(url)
+	}
+	song := match.Metadata.Title
+	if match.Metadata.Artist != "" {
+		song = fmt.Sprintf("%s — %s", song, match.Metadata.Artist)
+	}
+	return linkGroup{
+		link:           match.Track.URL(),
+		trackIDs:       []spotify.ID{match.Track.ID},
+		source:         url,
+		sourcePlatform: platform.Name(),
+		sourceSong:     song,
+		match:          match.Track.String(),
+		confidence:     match.Confidence,
+		uncertain:      match.Confidence < c.config.MatchThreshold,
+	}
+}
+
+// resolveLink returns the full link a short link leads to. Other links are returned unchanged.
+func (c *Client) resolveLink(ctx context.Context, url string) (string, error) {
+	link, ok := track.ParseLink(url)
+	if !ok || link.Kind != track.KindShort {
+		return url, nil
+	}
+	link, err := c.resolver.Resolve(ctx, link)
+	if err != nil {
+		return url, err
+	}
+	return link.URL(), nil
+}
+
+// albumTrackIDs fetches the IDs of an album's tracks in order, stopping after limit tracks (0 for all).
+func (c *Client) albumTrackIDs(ctx context.Context, api *spotify.Client, albumID string, limit int) ([]spotify.ID, error) {
+	var trackIDs []spotify.ID
+	page, err := api.GetAlbumTracks(ctx, spotify.ID(albumID), spotify.Limit(albumPageSize))
+	for ; err == nil; err = api.NextPage(ctx, page) {
+		for _, t := range page.Tracks {
+			trackIDs = append(trackIDs, t.ID)
+			if limit > 0 && len(trackIDs) >= limit {
+				return trackIDs, nil
+			}
+		}
+		if page.Next == "" {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return trackIDs, nil
+}
+
+// playlistTrackIDs fetches the IDs of a playlist's tracks in order, stopping after limit tracks (0 for all).
+// Episodes and unavailable items are skipped.
+func (c *Client) playlistTrackIDs(ctx context.Context, api *spotify.Client, playlistID string, limit int) ([]spotify.ID, error) {
+	var trackIDs []spotify.ID
+	page, err := api.GetPlaylistItems(ctx, spotify.ID(playlistID), spotify.Limit(playlistPageSize))
+	for ; err == nil; err = api.NextPage(ctx, page) {
+		for _, item := range page.Items {
+			if item.Item.Track == nil || item.IsLocal {
+				continue
+			}
+			trackIDs = append(trackIDs, item.Item.Track.ID)
+			if limit > 0 && len(trackIDs) >= limit {
+				return trackIDs, nil
+			}
+		}
+		if page.Next == "" {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return trackIDs, nil
+}
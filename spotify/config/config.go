@@ -4,26 +4,114 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"discordbot/constants/envvar"
+	"discordbot/spotify/worker"
 )
 
 // Config represents the configuration for the Spotify client
 type Config struct {
-	WorkerURL            string // Base URL of the Cloudflare Worker
-	CFAccessClientID     string // CF Access service token client ID
-	CFAccessClientSecret string // CF Access service token client secret
-	PlaylistID           string // Spotify playlist ID to add tracks to
+	WorkerURL            string        // Base URL of the Cloudflare Worker
+	CFAccessClientID     string        // CF Access service token client ID
+	CFAccessClientSecret string        // CF Access service token client secret
+	WorkerTimeout        time.Duration // Longest a single call to the worker may take
+	WorkerRetries        int           // Retries of worker calls failing on trouble that may pass
+
+	WorkerBreakerThreshold int           // Consecutive worker failures before calls fail fast; 0 never fails fast
+	WorkerBreakerCooldown  time.Duration // How long calls fail fast before the worker is tried again
+
+	PlaylistID string // Default Spotify playlist ID; playlists are chosen per guild and channel by the Discord config
+
+	MaxAlbumTracks    int // Most tracks added from one album link; 0 adds all of them
+	MaxPlaylistTracks int // Most tracks imported from one playlist link; 0 imports all of them
+	ConfirmThreshold  int // Album and playlist links adding more tracks than this need confirmation
+
+	MetadataProviderURL string  // Service that looks up songs linked on other platforms; empty disables conversion
+	MatchThreshold      float64 // Converted links matching with less confidence than this need confirmation
+
+	PlaylistCachePath string // File the playlist cache is kept in across restarts; empty keeps it in memory only
+
+	BatchWindow time.Duration // How long submissions to a playlist are collected into one write; 0 writes each alone
+
+	RetryQueuePath string        // File submissions waiting to be retried are kept in across restarts; empty keeps them in memory only
+	RetryTTL       time.Duration // How long a submission is retried before giving up; 0 retries forever
 }
 
+// Defaults for album and playlist expansion
+const (
+	DefaultMaxAlbumTracks    = 0
+	DefaultMaxPlaylistTracks = 200
+	DefaultConfirmThreshold  = 10
+)
+
+// DefaultMatchThreshold is the default confidence needed to add a converted link without confirmation
+const DefaultMatchThreshold = 0.8
+
+// DefaultBatchWindow is how long submissions to a playlist are collected into one write by default
+const DefaultBatchWindow = 2 * time.Second
+
+// DefaultRetryQueuePath is the file submissions waiting to be retried are kept in by default
+const DefaultRetryQueuePath = "data/retries.json"
+
+// DefaultRetryTTL is how long a submission is retried by default before giving up
+const DefaultRetryTTL = 24 * time.Hour
+
 // NewConfig creates a new configuration struct for the Spotify client
 func NewConfig(opts ...Option) (*Config, error) {
 	c := &Config{
 		WorkerURL:            os.Getenv(envvar.SpotifyWorkerURL),
 		CFAccessClientID:     os.Getenv(envvar.CFAccessClientID),
 		CFAccessClientSecret: os.Getenv(envvar.CFAccessClientSecret),
-		PlaylistID:           os.Getenv(envvar.SpotifyPlaylistID),
+		WorkerTimeout:        worker.DefaultTimeout,
+		WorkerRetries:        worker.DefaultRetries,
+
+		WorkerBreakerThreshold: worker.DefaultBreakerThreshold,
+		WorkerBreakerCooldown:  worker.DefaultBreakerCooldown,
+
+		PlaylistID:          os.Getenv(envvar.SpotifyPlaylistID),
+		MaxAlbumTracks:      DefaultMaxAlbumTracks,
+		MaxPlaylistTracks:   DefaultMaxPlaylistTracks,
+		ConfirmThreshold:    DefaultConfirmThreshold,
+		MetadataProviderURL: os.Getenv(envvar.MetadataProviderURL),
+		MatchThreshold:      DefaultMatchThreshold,
+		PlaylistCachePath:   os.Getenv(envvar.SpotifyPlaylistCachePath),
+		BatchWindow:         DefaultBatchWindow,
+		RetryQueuePath:      DefaultRetryQueuePath,
+		RetryTTL:            DefaultRetryTTL,
+	}
+	for key, field := range map[string]*int{
+		envvar.SpotifyMaxAlbumTracks:         &c.MaxAlbumTracks,
+		envvar.SpotifyMaxPlaylistTracks:      &c.MaxPlaylistTracks,
+		envvar.SpotifyConfirmThreshold:       &c.ConfirmThreshold,
+		envvar.SpotifyWorkerRetries:          &c.WorkerRetries,
+		envvar.SpotifyWorkerBreakerThreshold: &c.WorkerBreakerThreshold,
+	} {
+		if err := intFromEnv(key, field); err != nil {
+			return nil, err
+		}
+	}
+	if val := os.Getenv(envvar.SpotifyRetryQueuePath); val != "" {
+		c.RetryQueuePath = val
+	}
+	if val := os.Getenv(envvar.SpotifyMatchThreshold); val != "" {
+		threshold, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envvar.SpotifyMatchThreshold, err)
+		}
+		c.MatchThreshold = threshold
+	}
+	for key, field := range map[string]*time.Duration{
+		envvar.SpotifyBatchWindow:           &c.BatchWindow,
+		envvar.SpotifyRetryTTL:              &c.RetryTTL,
+		envvar.SpotifyWorkerTimeout:         &c.WorkerTimeout,
+		envvar.SpotifyWorkerBreakerCooldown: &c.WorkerBreakerCooldown,
+	} {
+		if err := durationFromEnv(key, field); err != nil {
+			return nil, err
+		}
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -48,12 +136,47 @@ func (c *Config) Validate() error {
 	if c.CFAccessClientSecret == "" {
 		missing = append(missing, "CF Access Client Secret")
 	}
-	if c.PlaylistID == "" {
-		missing = append(missing, "Spotify Playlist ID")
-	}
 	if len(missing) > 0 {
 		return fmt.Errorf("missing env vars: %s", strings.Join(missing, ", "))
 	}
+	if c.WorkerTimeout <= 0 {
+		return fmt.Errorf("worker timeout must be positive")
+	}
+	if c.WorkerRetries < 0 {
+		return fmt.Errorf("worker retries must not be negative")
+	}
+	if c.WorkerBreakerThreshold < 0 {
+		return fmt.Errorf("worker breaker threshold must not be negative")
+	}
+	if c.WorkerBreakerCooldown <= 0 {
+		return fmt.Errorf("worker breaker cooldown must be positive")
+	}
+	if c.MaxAlbumTracks < 0 || c.MaxPlaylistTracks < 0 || c.ConfirmThreshold < 0 {
+		return fmt.Errorf("track limits must not be negative")
+	}
+	if c.MatchThreshold < 0 || c.MatchThreshold > 1 {
+		return fmt.Errorf("match threshold must be between 0 and 1")
+	}
+	if c.BatchWindow < 0 {
+		return fmt.Errorf("batch window must not be negative")
+	}
+	if c.RetryTTL < 0 {
+		return fmt.Errorf("retry TTL must not be negative")
+	}
+	return nil
+}
+
+// intFromEnv overrides *field with the integer value of the environment variable key, if set
+func intFromEnv(key string, field *int) error {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*field = n
 	return nil
 }
 
@@ -74,3 +197,22 @@ func WithCFAccessClientID(id string) Option {
 func WithCFAccessClientSecret(s string) Option {
 	return func(c *Config) { c.CFAccessClientSecret = s }
 }
+
+// WithConfirmThreshold overrides the number of tracks a link may add without confirmation.
+func WithConfirmThreshold(n int) Option {
+	return func(c *Config) { c.ConfirmThreshold = n }
+}
+
+// durationFromEnv overrides *field with the duration value of the environment variable key, if set
+func durationFromEnv(key string, field *time.Duration) error {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*field = d
+	return nil
+}
@@ -0,0 +1,154 @@
+package convert
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"discordbot/spotify/track"
+)
+
+const (
+	// searchLimit is the number of Spotify results scored when matching by title and artist
+	searchLimit = 5
+
+	// isrcConfidence is the confidence of a match found by ISRC, which identifies the recording
+	isrcConfidence = 1.0
+
+	// titleWeight and artistWeight weigh title and artist similarity in the confidence score
+	titleWeight  = 0.6
+	artistWeight = 0.4
+)
+
+// SearchFunc searches the Spotify catalog for tracks matching query
+type SearchFunc func(ctx context.Context, query string, limit int) ([]track.Summary, error)
+
+// Match is the Spotify track that best matches a link
+type Match struct {
+	Metadata   Metadata
+	Track      track.Summary
+	Confidence float64 // From 0 to 1; how sure we are that Track is the linked song
+}
+
+// Converter converts song links from other platforms into Spotify tracks
+type Converter struct {
+	provider MetadataProvider
+}
+
+// NewConverter creates a converter that looks up songs with provider
+func NewConverter(provider MetadataProvider) *Converter {
+	return &Converter{provider: provider}
+}
+
+// Convert finds the Spotify track that best matches the song at link
+func (c *Converter) Convert(ctx context.Context, link string, search SearchFunc) (Match, error) {
+	metadata, err := c.provider.Metadata(ctx, link)
+	if err != nil {
+		return Match{}, fmt.Errorf("failed to look up %s: %w", link, err)
+	}
+	metadata = cleanMetadata(metadata)
+
+	// An ISRC identifies the recording, so any track found by it is the same song
+	if metadata.ISRC != "" {
+		results, err := search(ctx, "isrc:"+metadata.ISRC, 1)
+		if err != nil {
+			return Match{}, fmt.Errorf("failed to search by ISRC: %w", err)
+		}
+		if len(results) > 0 {
+			return Match{Metadata: metadata, Track: results[0], Confidence: isrcConfidence}, nil
+		}
+	}
+
+	query := metadata.Title
+	if metadata.Artist != "" {
+		query = fmt.Sprintf("track:%s artist:%s", metadata.Title, metadata.Artist)
+	}
+	results, err := search(ctx, query, searchLimit)
+	if err != nil {
+		return Match{}, fmt.Errorf("failed to search by title: %w", err)
+	}
+	if len(results) == 0 && metadata.Artist != "" {
+		// Field filters are strict; retry as a free-text search
+		results, err = search(ctx, metadata.Title+" "+metadata.Artist, searchLimit)
+		if err != nil {
+			return Match{}, fmt.Errorf("failed to search by title: %w", err)
+		}
+	}
+
+	var best Match
+	for _, result := range results {
+		if confidence := score(metadata, result); confidence > best.Confidence {
+			best = Match{Metadata: metadata, Track: result, Confidence: confidence}
+		}
+	}
+	if best.Track.ID == "" {
+		return Match{}, fmt.Errorf("no Spotify track matches %q by %q", metadata.Title, metadata.Artist)
+	}
+	return best, nil
+}
+
+// --- Scoring ---
+
+var (
+	// noiseRegex matches parts of video and upload titles that are not part of the song title
+	noiseRegex = regexp.MustCompile(`(?i)[(\[](official|lyrics?|audio|video|music video|hd|hq|4k|visuali[sz]er|remaster(ed)?)[^)\]]*[)\]]`)
+
+	// featRegex matches featured artist credits
+	featRegex = regexp.MustCompile(`(?i)\s*[(\[]?\b(feat|ft|featuring)\b\.?.*$`)
+
+	// channelSuffixRegex matches suffixes YouTube adds to artist channel names
+	channelSuffixRegex = regexp.MustCompile(`(?i)(\s*-\s*topic|vevo|\s+official)$`)
+)
+
+// cleanMetadata strips upload noise from metadata, e.g. "Artist - Song (Official Video)" titles
+// posted on channels named "ArtistVEVO"
+func cleanMetadata(m Metadata) Metadata {
+	m.Title = strings.TrimSpace(noiseRegex.ReplaceAllString(m.Title, ""))
+	m.Artist = strings.TrimSpace(channelSuffixRegex.ReplaceAllString(m.Artist, ""))
+	if artist, title, ok := strings.Cut(m.Title, " - "); ok && (m.Artist == "" || similarity(artist, m.Artist) > 0) {
+		m.Artist, m.Title = strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	m.Title = strings.TrimSpace(featRegex.ReplaceAllString(m.Title, ""))
+	return m
+}
+
+// score returns how likely it is that a Spotify track is the song described by metadata
+func score(m Metadata, t track.Summary) float64 {
+	title := similarity(m.Title, featRegex.ReplaceAllString(t.Name, ""))
+	if m.Artist == "" {
+		return title
+	}
+	artist := 0.0
+	for _, name := range t.Artists {
+		artist = max(artist, similarity(m.Artist, name))
+	}
+	return titleWeight*title + artistWeight*artist
+}
+
+// similarity returns the Dice coefficient of the words in a and b, from 0 (disjoint) to 1 (same words)
+func similarity(a, b string) float64 {
+	wordsA, wordsB := words(a), words(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	shared := 0
+	for word := range wordsA {
+		if _, ok := wordsB[word]; ok {
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(wordsA)+len(wordsB))
+}
+
+// words returns the set of lower-cased words in s, ignoring punctuation
+func words(s string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		set[word] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,88 @@
+// Package convert converts links to songs on other streaming platforms into Spotify tracks
+package convert
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Platform is a streaming platform that links can be converted from
+type Platform string
+
+const (
+	YouTube    Platform = "youtube"
+	AppleMusic Platform = "apple_music"
+	Tidal      Platform = "tidal"
+	Deezer     Platform = "deezer"
+	SoundCloud Platform = "soundcloud"
+)
+
+// platformNames are the names platforms are shown to users with
+var platformNames = map[Platform]string{
+	YouTube:    "YouTube",
+	AppleMusic: "Apple Music",
+	Tidal:      "Tidal",
+	Deezer:     "Deezer",
+	SoundCloud: "SoundCloud",
+}
+
+// Name returns the name the platform is shown to users with
+func (p Platform) Name() string {
+	if name, ok := platformNames[p]; ok {
+		return name
+	}
+	return string(p)
+}
+
+// platformHosts maps the hosts that serve song links to their platform
+var platformHosts = map[string]Platform{
+	"youtube.com":       YouTube,
+	"m.youtube.com":     YouTube,
+	"music.youtube.com": YouTube,
+	"youtu.be":          YouTube,
+	"music.apple.com":   AppleMusic,
+	"tidal.com":         Tidal,
+	"listen.tidal.com":  Tidal,
+	"deezer.com":        Deezer,
+	"deezer.page.link":  Deezer,
+	"link.deezer.com":   Deezer,
+	"soundcloud.com":    SoundCloud,
+	"m.soundcloud.com":  SoundCloud,
+	"on.soundcloud.com": SoundCloud,
+}
+
+var (
+	// urlRegex matches http(s) URLs, optionally wrapped in angle brackets to suppress embeds
+	urlRegex = regexp.MustCompile(`<?https?://[^\s<>]+`)
+)
+
+// trailingPunctuation is stripped from the end of URLs, where it usually belongs to the sentence
+// (or markdown) around the link rather than the link itself
+const trailingPunctuation = `.,;:!?'")]}*_~|`
+
+// DetectPlatform returns the platform a song link belongs to
+func DetectPlatform(link string) (Platform, bool) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	platform, ok := platformHosts[host]
+	if !ok || strings.Trim(u.Path, "/") == "" {
+		return "", false
+	}
+	return platform, true
+}
+
+// ExtractURLs extracts links to songs on supported platforms from the given content
+func ExtractURLs(content string) ([]string, bool) {
+	var urls []string
+	for _, match := range urlRegex.FindAllString(content, -1) {
+		link := strings.TrimRight(strings.TrimPrefix(match, "<"), trailingPunctuation+">")
+		if _, ok := DetectPlatform(link); ok {
+			urls = append(urls, link)
+		}
+	}
+	return urls, len(urls) > 0
+}
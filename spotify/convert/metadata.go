@@ -0,0 +1,82 @@
+package convert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Metadata describes the song a link refers to
+type Metadata struct {
+	Title    string   `json:"title"`
+	Artist   string   `json:"artist"`
+	ISRC     string   `json:"isrc,omitempty"` // International Standard Recording Code, when the platform exposes it
+	Platform Platform `json:"platform,omitempty"`
+}
+
+// MetadataProvider looks up the song a link refers to
+type MetadataProvider interface {
+	Metadata(ctx context.Context, link string) (Metadata, error)
+}
+
+// HTTPProvider looks up song metadata from an HTTP service.
+//
+// The service is called as GET <baseURL>?url=<link> and must answer with a JSON object
+// {"title": "...", "artist": "...", "isrc": "..."}; isrc is optional. Any service honouring
+// that contract can be used, including a local stand-in during development.
+type HTTPProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPProvider creates a provider calling the service at baseURL, giving up after timeout
+func NewHTTPProvider(baseURL string, timeout time.Duration) *HTTPProvider {
+	return &HTTPProvider{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Metadata looks up the song a link refers to
+func (p *HTTPProvider) Metadata(ctx context.Context, link string) (Metadata, error) {
+	platform, ok := DetectPlatform(link)
+	if !ok {
+		return Metadata{}, fmt.Errorf("unsupported link: %s", link)
+	}
+
+	reqURL, err := url.Parse(p.baseURL)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("invalid metadata provider URL: %w", err)
+	}
+	query := reqURL.Query()
+	query.Set("url", link)
+	reqURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("metadata request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return Metadata{}, fmt.Errorf("metadata provider returned %d: %s", resp.StatusCode, body)
+	}
+	var metadata Metadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return Metadata{}, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	if metadata.Title == "" {
+		return Metadata{}, fmt.Errorf("metadata provider did not find a song for %s", link)
+	}
+	metadata.Platform = platform
+	return metadata, nil
+}
@@ -1,6 +1,9 @@
 package spotify
 
-import "discordbot/spotify/config"
+import (
+	"discordbot/ledger"
+	"discordbot/spotify/config"
+)
 
 // Option is a function that configures a Client
 type Option func(*Client) error
@@ -19,3 +22,11 @@ func WithConfig(cfg *config.Config) Option {
 		return nil
 	}
 }
+
+// WithLedger configures the client to record added tracks in the ledger
+func WithLedger(l *ledger.Ledger) Option {
+	return func(c *Client) error {
+		c.ledger = l
+		return nil
+	}
+}
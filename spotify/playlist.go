@@ -2,6 +2,7 @@ package spotify
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"go.uber.org/zap"
@@ -9,11 +10,12 @@ import (
 	"github.com/jdcukier/spotify/v2"
 
 	"discordbot/constants/zapkey"
+	"discordbot/spotify/playlistcache"
 	"discordbot/utils/ctxutil"
 )
 
 // playlist gets metadata about the specified playlist from spotify.
-// Note: This does not include the list of tracks. Use allPlaylistTrackIDs to get the full set of track IDs.
+// Note: This does not include the list of tracks. Use existingTrackIDs to get the full set of track IDs.
 func (c *Client) playlist(ctx context.Context, api *spotify.Client, playlistID string) (*spotify.FullPlaylist, error) {
 	if playlistID == "" {
 		return nil, fmt.Errorf("no playlist ID provided")
@@ -21,6 +23,52 @@ func (c *Client) playlist(ctx context.Context, api *spotify.Client, playlistID s
 	return api.GetPlaylist(ctx, spotify.ID(playlistID))
 }
 
+// CheckPlaylists verifies that each playlist exists and is reachable with the given Discord
+// user's Spotify session. Every unreachable playlist is reported in the returned error.
+func (c *Client) CheckPlaylists(ctx context.Context, userID string, playlistIDs []string) error {
+	api := c.spotifyClientForUser(userID)
+	var errs []error
+	for _, playlistID := range playlistIDs {
+		playlist, err := c.playlist(ctx, api, playlistID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("playlist %s is not reachable: %w", playlistID, err))
+			continue
+		}
+		logger.Info("Playlist is reachable",
+			zap.String(zapkey.PlaylistID, playlistID),
+			zap.String(zapkey.Name, playlist.Name))
+	}
+	return errors.Join(errs...)
+}
+
+// existingTrackIDs returns the track IDs in a playlist. Only the playlist's snapshot ID is fetched
+// if the tracks are cached at that snapshot; otherwise every page is fetched and cached.
+// The returned set is shared and must not be modified.
+func (c *Client) existingTrackIDs(ctx context.Context, api *spotify.Client, playlistID string) (playlistcache.TrackIDs, error) {
+	if playlistID == "" {
+		return nil, fmt.Errorf("no playlist ID provided")
+	}
+	fields := ctxutil.ZapFields(ctx)
+
+	pl, err := api.GetPlaylist(ctx, spotify.ID(playlistID), spotify.Fields("snapshot_id"))
+	if err != nil {
+		return nil, err
+	}
+	if trackIDs, ok := c.playlists.Get(playlistID, pl.SnapshotID); ok {
+		logger.With(zap.Int(zapkey.Count, len(trackIDs))).Debug("Playlist cache hit", fields...)
+		return trackIDs, nil
+	}
+
+	// The snapshot is fetched first, so changes made while paging make the next call refetch
+	trackIDs, err := c.allPlaylistTrackIDs(ctx, api, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	c.playlists.Put(playlistID, pl.SnapshotID, trackIDs)
+	logger.With(zap.Int(zapkey.Count, len(trackIDs))).Info("Cached playlist tracks", fields...)
+	return trackIDs, nil
+}
+
 // allPlaylistTrackIDs fetches all track IDs in a playlist, paginating through every page.
 func (c *Client) allPlaylistTrackIDs(ctx context.Context, api *spotify.Client, playlistID string) (map[spotify.ID]struct{}, error) {
 	if playlistID == "" {
@@ -44,6 +92,27 @@ func (c *Client) allPlaylistTrackIDs(ctx context.Context, api *spotify.Client, p
 	return existing, nil
 }
 
+// maxTracksPerRequest is the most tracks Spotify accepts in a single add request
+const maxTracksPerRequest = 100
+
+// addTracks adds tracks to a playlist, splitting them into as many requests as needed.
+// Returns the tracks that were added and the snapshot ID after the last successful request,
+// which may be a partial set if a request fails.
+func (c *Client) addTracks(ctx context.Context, api *spotify.Client, playlistID string, trackIDs []spotify.ID) ([]spotify.ID, string, error) {
+	var added []spotify.ID
+	var snapshotID string
+	for start := 0; start < len(trackIDs); start += maxTracksPerRequest {
+		chunk := trackIDs[start:min(start+maxTracksPerRequest, len(trackIDs))]
+		snapshot, err := api.AddTracksToPlaylist(ctx, spotify.ID(playlistID), chunk...)
+		if err != nil {
+			return added, snapshotID, err
+		}
+		added = append(added, chunk...)
+		snapshotID = snapshot
+	}
+	return added, snapshotID, nil
+}
+
 // logPlaylistInfo logs information about the specified playlist.
 func (c *Client) logPlaylistInfo(ctx context.Context, api *spotify.Client, playlistID string) {
 	// Logging metadata
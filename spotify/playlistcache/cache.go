@@ -0,0 +1,215 @@
+// Package playlistcache caches the tracks in playlists, keyed on the playlist's snapshot ID
+package playlistcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/jdcukier/spotify/v2"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+)
+
+// TrackIDs is a set of track IDs. Sets returned by the cache are shared and must not be modified.
+type TrackIDs map[spotify.ID]struct{}
+
+// entry is the content of a playlist at a snapshot
+type entry struct {
+	snapshotID string
+	trackIDs   TrackIDs
+}
+
+// Cache holds the tracks in playlists. An entry is valid for as long as the playlist's snapshot
+// ID is the one it was cached at, and is updated in place by the bot's own adds and removes so
+// that they do not invalidate it. Entries are replaced rather than modified, so sets handed out
+// stay consistent.
+//
+// If the cache has a path, it is loaded from and saved to that file so it survives restarts.
+type Cache struct {
+	path string
+
+	mu        sync.RWMutex
+	playlists map[string]entry // Keyed by playlist ID
+
+	saveMu sync.Mutex
+}
+
+// New creates a cache, loading it from path if given. A missing file is an empty cache.
+func New(path string) (*Cache, error) {
+	c := &Cache{path: path, playlists: make(map[string]entry)}
+	if path == "" {
+		return c, nil
+	}
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("loading playlist cache: %w", err)
+	}
+	logger.Info("Playlist cache loaded", zap.String(zapkey.Path, path), zap.Int(zapkey.Count, len(c.playlists)))
+	return c, nil
+}
+
+// Get returns the tracks in a playlist if they were cached at snapshotID
+func (c *Cache) Get(playlistID, snapshotID string) (TrackIDs, bool) {
+	if c == nil || snapshotID == "" {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.playlists[playlistID]
+	if !ok || e.snapshotID != snapshotID {
+		return nil, false
+	}
+	return e.trackIDs, true
+}
+
+// Tracks returns the last known tracks in a playlist, whatever its snapshot. Use it to skip tracks
+// written since a caller checked the playlist, not in place of Get.
+func (c *Cache) Tracks(playlistID string) (TrackIDs, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.playlists[playlistID]
+	return e.trackIDs, ok
+}
+
+// Put caches the tracks in a playlist at snapshotID
+func (c *Cache) Put(playlistID, snapshotID string, trackIDs TrackIDs) {
+	if c == nil || snapshotID == "" {
+		return
+	}
+	c.mu.Lock()
+	c.playlists[playlistID] = entry{snapshotID: snapshotID, trackIDs: maps.Clone(trackIDs)}
+	c.mu.Unlock()
+	c.save()
+}
+
+// Apply updates a cached playlist with tracks the bot added and removed, moving it to the
+// snapshot the change produced. Playlists that are not cached are left alone. Without a
+// snapshot ID, e.g. after a partly failed change, the playlist is dropped from the cache.
+func (c *Cache) Apply(playlistID, snapshotID string, added, removed []spotify.ID) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	e, ok := c.playlists[playlistID]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	if snapshotID == "" {
+		delete(c.playlists, playlistID)
+	} else {
+		trackIDs := maps.Clone(e.trackIDs)
+		for _, trackID := range added {
+			trackIDs[trackID] = struct{}{}
+		}
+		for _, trackID := range removed {
+			delete(trackIDs, trackID)
+		}
+		c.playlists[playlistID] = entry{snapshotID: snapshotID, trackIDs: trackIDs}
+	}
+	c.mu.Unlock()
+	c.save()
+}
+
+// Invalidate drops a playlist from the cache
+func (c *Cache) Invalidate(playlistID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.playlists, playlistID)
+	c.mu.Unlock()
+	c.save()
+}
+
+// --- Persistence ---
+
+// file is the format the cache is saved in
+type file struct {
+	Playlists map[string]filePlaylist `json:"playlists"`
+}
+
+type filePlaylist struct {
+	SnapshotID string       `json:"snapshot_id"`
+	TrackIDs   []spotify.ID `json:"track_ids"`
+}
+
+// load reads the cache file. A missing or unreadable cache only costs refetching playlists,
+// so a corrupt file is logged and ignored.
+func (c *Cache) load() error {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		logger.With(zap.Error(err)).Warn("Ignoring unreadable playlist cache", zap.String(zapkey.Path, c.path))
+		return nil
+	}
+	for playlistID, p := range f.Playlists {
+		trackIDs := make(TrackIDs, len(p.TrackIDs))
+		for _, trackID := range p.TrackIDs {
+			trackIDs[trackID] = struct{}{}
+		}
+		c.playlists[playlistID] = entry{snapshotID: p.SnapshotID, trackIDs: trackIDs}
+	}
+	return nil
+}
+
+// save writes the cache to its file, if it has one. The file is replaced atomically so a crash
+// mid-write leaves the previous version.
+func (c *Cache) save() {
+	if c.path == "" {
+		return
+	}
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.RLock()
+	f := file{Playlists: make(map[string]filePlaylist, len(c.playlists))}
+	for playlistID, e := range c.playlists {
+		f.Playlists[playlistID] = filePlaylist{SnapshotID: e.snapshotID, TrackIDs: slices.Sorted(maps.Keys(e.trackIDs))}
+	}
+	c.mu.RUnlock()
+
+	if err := writeFile(c.path, f); err != nil {
+		logger.With(zap.Error(err)).Warn("Failed to save playlist cache", zap.String(zapkey.Path, c.path))
+	}
+}
+
+// writeFile atomically replaces the file at path with f encoded as JSON
+func writeFile(path string, f file) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("encoding playlist cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating playlist cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating playlist cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing playlist cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing playlist cache: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,7 @@
+package playlistcache
+
+import (
+	"discordbot/log"
+)
+
+var logger = log.Logger.Named("playlistcache")
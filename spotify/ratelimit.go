@@ -0,0 +1,135 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRetries is the most times a request is retried after a 429 or 5xx response
+	maxRetries = 3
+
+	// retryBaseDelay is the backoff before the first retry when Spotify gives no Retry-After
+	retryBaseDelay = 500 * time.Millisecond
+
+	// retryMaxDelay caps the backoff between retries when Spotify gives no Retry-After
+	retryMaxDelay = 30 * time.Second
+)
+
+// ErrRateLimited is returned when Spotify asks the bot to wait longer than a request may take
+var ErrRateLimited = errors.New("spotify rate limit exceeded")
+
+// rateLimiter holds back requests while Spotify is rate limiting the app. Spotify's limit applies
+// to the app rather than to each user's token, so one limiter is shared by every request.
+type rateLimiter struct {
+	mu    sync.Mutex
+	until time.Time // No requests may be sent before this time
+}
+
+// newRateLimiter creates a rate limiter that is not holding back requests
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{}
+}
+
+// wait blocks until requests may be sent again. If that is after ctx's deadline, it returns
+// ErrRateLimited immediately rather than waiting in vain.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	delay := time.Until(l.until)
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	if !fitsDeadline(ctx, delay) {
+		return fmt.Errorf("%w; retry in %s", ErrRateLimited, delay.Round(time.Second))
+	}
+	return sleep(ctx, delay)
+}
+
+// backoff holds back requests for delay, unless they are already held back for longer
+func (l *rateLimiter) backoff(delay time.Duration) {
+	if l == nil {
+		return
+	}
+	until := time.Now().Add(delay)
+	l.mu.Lock()
+	if until.After(l.until) {
+		l.until = until
+	}
+	l.mu.Unlock()
+}
+
+// retryable reports whether a request that got resp may be sent again. A 429 means Spotify did
+// not process the request, so any request can be retried. After a 5xx the request may have been
+// applied, so only requests that are safe to repeat are retried.
+func retryable(req *http.Request, resp *http.Response) bool {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return idempotent(req.Method)
+	default:
+		return false
+	}
+}
+
+// idempotent reports whether repeating a request with method has the same effect as sending it once
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryDelay returns how long to wait before retrying after resp: the Retry-After Spotify gave,
+// or else an exponential backoff with full jitter
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		return delay
+	}
+	backoff := min(retryBaseDelay<<attempt, retryMaxDelay)
+	return rand.N(backoff) + 1
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(val string) (time.Duration, bool) {
+	if val == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(val); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(val); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// fitsDeadline reports whether waiting for delay leaves ctx time to send a request
+func fitsDeadline(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Now().Add(delay).Before(deadline)
+}
+
+// sleep waits for delay or until ctx is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
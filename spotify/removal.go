@@ -0,0 +1,108 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jdcukier/spotify/v2"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/ledger"
+	"discordbot/spotify/track"
+	"discordbot/utils/ctxutil"
+)
+
+// -- Removal ---
+
+// CancelQueued gives up on the queued retries of the tracks submitted in a Discord message, e.g.
+// because the message was deleted. Returns how many queued submissions were cancelled.
+func (c *Client) CancelQueued(ctx context.Context, messageID string) (int, error) {
+	cancelled, err := c.retrier.withdraw(messageID)
+	if cancelled > 0 {
+		logger.With(zap.Int(zapkey.Count, cancelled)).Info("Cancelled queued submissions",
+			append(ctxutil.ZapFields(ctx), zap.String(zapkey.MessageID, messageID))...)
+	}
+	if err != nil {
+		return cancelled, fmt.Errorf("cancelling queued submissions: %w", err)
+	}
+	return cancelled, nil
+}
+
+// RemoveSubmission withdraws the tracks the bot added for a Discord message, e.g. because the
+// message was deleted. Only tracks added at or after addedAfter are removed, and a track is kept
+// if anyone else has also submitted it. Returns the IDs of the tracks that were removed.
+func (c *Client) RemoveSubmission(ctx context.Context, messageID string, addedAfter time.Time) ([]spotify.ID, error) {
+	if c.ledger == nil {
+		return nil, fmt.Errorf("no ledger configured; cannot tell which tracks the message added")
+	}
+	ctx, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.MessageID, messageID))
+
+	// Group the message's removable tracks by playlist and submitter
+	type target struct {
+		sub      track.Submission
+		trackIDs []spotify.ID
+	}
+	targets := make(map[string]*target)
+	for _, entry := range c.ledger.ByMessage(messageID) {
+		if entry.EventKind() != ledger.KindAdded || entry.AddedAt.Before(addedAfter) {
+			continue
+		}
+		if current, ok := c.ledger.Find(entry.PlaylistID, entry.TrackID); !ok || current.MessageID != messageID {
+			// Already removed, or re-added by someone else since
+			continue
+		}
+		if c.submittedElsewhere(entry) {
+			logger.With(zap.String(zapkey.TrackID, entry.TrackID)).Info("Keeping track submitted by others", fields...)
+			continue
+		}
+		key := entry.PlaylistID + "/" + entry.UserID
+		if _, ok := targets[key]; !ok {
+			targets[key] = &target{sub: track.Submission{
+				UserID:     entry.UserID,
+				GuildID:    entry.GuildID,
+				ChannelID:  entry.ChannelID,
+				MessageID:  entry.MessageID,
+				PlaylistID: entry.PlaylistID,
+			}}
+		}
+		targets[key].trackIDs = append(targets[key].trackIDs, spotify.ID(entry.TrackID))
+	}
+
+	var removed []spotify.ID
+	for _, t := range targets {
+		// The submitter's session is used since they added the tracks
+		api := c.spotifyClientForUser(t.sub.UserID)
+		unlock := c.batcher.lock(t.sub.PlaylistID)
+		snapshotID, err := api.RemoveTracksFromPlaylist(ctx, spotify.ID(t.sub.PlaylistID), t.trackIDs...)
+		if err != nil {
+			unlock()
+			return removed, fmt.Errorf("removing tracks from playlist %s: %w", t.sub.PlaylistID, err)
+		}
+		c.record(ctx, t.sub, ledger.KindRemoved, snapshotID, t.trackIDs)
+		c.playlists.Apply(t.sub.PlaylistID, snapshotID, nil, t.trackIDs)
+		unlock()
+		removed = append(removed, t.trackIDs...)
+		logger.With(
+			zap.String(zapkey.PlaylistID, t.sub.PlaylistID),
+			zap.Any(zapkey.TrackIDs, t.trackIDs),
+		).Info("Removed tracks for withdrawn submission", fields...)
+	}
+	return removed, nil
+}
+
+// submittedElsewhere reports whether the track added by entry has also been submitted to the
+// same playlist from another message since it was added
+func (c *Client) submittedElsewhere(entry ledger.Entry) bool {
+	for _, other := range c.ledger.Track(entry.PlaylistID, entry.TrackID) {
+		if other.AddedAt.Before(entry.AddedAt) || other.MessageID == entry.MessageID {
+			continue
+		}
+		if other.EventKind() == ledger.KindRemoved {
+			return false
+		}
+		return true
+	}
+	return false
+}
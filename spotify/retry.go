@@ -0,0 +1,366 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jdcukier/spotify/v2"
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/retryqueue"
+	"discordbot/spotify/track"
+	"discordbot/spotify/worker"
+	"discordbot/utils/ctxutil"
+)
+
+const (
+	// retryPollInterval is how often the retry queue is checked for due and expired submissions
+	retryPollInterval = 30 * time.Second
+
+	// retryAttemptTimeout bounds a single retry of a submission
+	retryAttemptTimeout = 2 * time.Minute
+
+	// retryBackoffBase is the wait before the first retry of a failed submission; it doubles
+	// with every further attempt, up to retryBackoffMax
+	retryBackoffBase = 30 * time.Second
+	retryBackoffMax  = 30 * time.Minute
+
+	// retryRetention is how long settled submissions stay in the queue, for inspection
+	retryRetention = 7 * 24 * time.Hour
+
+	// pendingAuthCheckInterval is how often the worker is asked whether users with submissions
+	// waiting for auth have connected Spotify, e.g. through a login link sent before a restart
+	pendingAuthCheckInterval = 10 * time.Minute
+)
+
+var (
+	// ErrRetryExpired is the error for submissions that could not be added before the retry TTL
+	ErrRetryExpired = errors.New("gave up retrying submission")
+
+	// ErrRetryCancelled is the error for submissions withdrawn while queued, e.g. by deleting them
+	ErrRetryCancelled = errors.New("submission withdrawn while queued")
+)
+
+// retrier retries submissions that could not be added straight away: those waiting for the
+// submitter to connect Spotify, and those that failed on worker or Spotify trouble that may go
+// away by itself. Submissions are kept in a retry queue so they survive restarts, and are given
+// up on once they are older than the TTL.
+type retrier struct {
+	client *Client
+	queue  *retryqueue.Queue
+	ttl    time.Duration // 0 keeps retrying forever
+
+	wake          chan struct{}
+	ctx           context.Context // Cancelled on stop, abandoning the attempt in progress
+	cancel        context.CancelFunc
+	done          chan struct{}
+	lastAuthCheck time.Time // When pending-auth submissions were last checked; only used by run
+}
+
+// newRetrier creates a retrier for the submissions in queue
+func newRetrier(client *Client, queue *retryqueue.Queue, ttl time.Duration) *retrier {
+	return &retrier{client: client, queue: queue, ttl: ttl, wake: make(chan struct{}, 1)}
+}
+
+// start resumes the queued submissions and starts retrying them in the background. Submissions
+// waiting for auth stay parked until their submitter has a token, without sending them another
+// login link.
+func (r *retrier) start() {
+	if pending := r.queue.List(retryqueue.StatePendingAuth, retryqueue.StateRetrying); len(pending) > 0 {
+		logger.Info("Resuming queued submissions", zap.Int(zapkey.Count, len(pending)))
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.done = make(chan struct{})
+	go r.run()
+}
+
+// stop stops retrying, abandoning the attempt in progress. Queued submissions are resumed on
+// the next start.
+func (r *retrier) stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+// enqueue queues a submission to be retried. Submissions waiting for auth are retried once the
+// submitter connects Spotify; failed submissions after a backoff.
+func (r *retrier) enqueue(ctx context.Context, sub track.Submission, state retryqueue.State, cause error) error {
+	next := time.Now()
+	if state == retryqueue.StateRetrying {
+		next = next.Add(retryBackoff(0))
+	}
+	var lastErr string
+	if cause != nil {
+		lastErr = cause.Error()
+	}
+	item, err := r.queue.Add(sub, state, next, lastErr)
+	if err != nil {
+		return err
+	}
+	logger.With(zap.String(zapkey.ID, item.ID), zap.String(zapkey.Status, string(state))).
+		Info("Queued submission for retry", ctxutil.ZapFields(ctx)...)
+	return nil
+}
+
+// resume makes the submissions waiting for a user to connect Spotify due now, and returns how
+// many there were
+func (r *retrier) resume(userID string) int {
+	now := time.Now().UTC()
+	var resumed int
+	for _, item := range r.queue.List(retryqueue.StatePendingAuth) {
+		if item.Submission.UserID != userID {
+			continue
+		}
+		if _, ok := r.update(item.ID, func(i *retryqueue.Item) {
+			i.State = retryqueue.StateRetrying
+			i.NextAttempt = now
+		}); ok {
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+	return resumed
+}
+
+// withdraw gives up on the queued submissions of a message and returns how many there were
+func (r *retrier) withdraw(messageID string) (int, error) {
+	if messageID == "" {
+		return 0, nil
+	}
+	var cancelled int
+	var errs []error
+	for _, item := range r.queue.List(retryqueue.StatePendingAuth, retryqueue.StateRetrying) {
+		if item.Submission.MessageID != messageID {
+			continue
+		}
+		_, err := r.queue.Update(item.ID, func(i *retryqueue.Item) {
+			i.State = retryqueue.StateCancelled
+			i.LastError = ErrRetryCancelled.Error()
+		})
+		if errors.Is(err, retryqueue.ErrCancelled) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+		cancelled++
+	}
+	return cancelled, errors.Join(errs...)
+}
+
+// run processes the queue until stopped
+func (r *retrier) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(retryPollInterval)
+	defer ticker.Stop()
+	for {
+		r.process()
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// process gives up on expired submissions and retries those that are due
+func (r *retrier) process() {
+	now := time.Now()
+	if _, err := r.queue.Prune(now.Add(-retryRetention)); err != nil {
+		logger.Error("Failed to prune retry queue", zap.Error(err))
+	}
+	if now.Sub(r.lastAuthCheck) >= pendingAuthCheckInterval {
+		r.lastAuthCheck = now
+		r.checkPendingAuth()
+	}
+	for _, item := range r.queue.List(retryqueue.StatePendingAuth, retryqueue.StateRetrying) {
+		if r.ctx.Err() != nil {
+			return
+		}
+		switch {
+		case r.ttl > 0 && now.Sub(item.CreatedAt) > r.ttl:
+			r.expire(item)
+		case item.State == retryqueue.StateRetrying && !item.NextAttempt.After(now):
+			r.attempt(item)
+		}
+	}
+}
+
+// checkPendingAuth resumes the submissions of users waiting for auth who have a token by now.
+// Users still without one are left waiting; no login link is sent.
+func (r *retrier) checkPendingAuth() {
+	checked := make(map[string]bool)
+	for _, item := range r.queue.List(retryqueue.StatePendingAuth) {
+		userID := item.Submission.UserID
+		if checked[userID] || r.ctx.Err() != nil {
+			continue
+		}
+		checked[userID] = true
+
+		ctx, cancel := context.WithTimeout(r.ctx, tokenFetchTimeout)
+		_, err := r.client.tokens.get(ctx, userID)
+		cancel()
+		switch {
+		case err == nil:
+			logger.Info("User has connected Spotify; resuming their queued submissions",
+				zap.String(zapkey.UserID, userID), zap.Int(zapkey.Count, r.resume(userID)))
+		case !errors.Is(err, worker.ErrAuthRequired):
+			logger.Warn("Cannot check whether user has connected Spotify",
+				zap.Error(err), zap.String(zapkey.UserID, userID))
+		}
+	}
+}
+
+// attempt tries to add a queued submission again and moves it to the state the outcome calls for
+func (r *retrier) attempt(item retryqueue.Item) {
+	sub := item.Submission
+	ctx := ctxutil.WithGuildID(r.ctx, sub.GuildID)
+	ctx, fields := ctxutil.WithZapFields(ctx,
+		zap.String(zapkey.ID, item.ID),
+		zap.String(zapkey.UserID, sub.UserID),
+		zap.Int(zapkey.Attempt, item.Attempts+1),
+	)
+	ctx, cancel := context.WithTimeout(ctx, retryAttemptTimeout)
+	defer cancel()
+
+	sub, err := r.client.resolveQuery(ctx, sub)
+	var results []track.Result
+	if err == nil {
+		results, err = r.client.doAddTracks(ctx, sub)
+	}
+	if err != nil && r.ctx.Err() != nil {
+		// Stopped mid-attempt; the submission is retried after the restart
+		return
+	}
+	if err == nil {
+		// Failures adding to tag playlists are queued on their own
+		results = append(results, r.client.addToTagPlaylists(ctx, sub)...)
+	}
+
+	switch {
+	case err == nil:
+		// The submission is settled, though its tracks may have turned out to be duplicates, to
+		// need confirmation, or to be unavailable on Spotify
+		status := track.Summarize(results)
+		if _, ok := r.update(item.ID, func(i *retryqueue.Item) {
+			i.State = retryqueue.StateDone
+			i.LastError = ""
+			if status == track.StatusFailed {
+				i.State = retryqueue.StateFailed
+				i.LastError = "no track could be added"
+			}
+		}); !ok {
+			return
+		}
+		logger.With(zap.String(zapkey.Status, string(status))).Info("Processed queued submission", fields...)
+		r.client.updateSubmission(ctx, sub, results)
+
+	case errors.Is(err, worker.ErrAuthRequired):
+		if _, ok := r.update(item.ID, func(i *retryqueue.Item) {
+			i.State = retryqueue.StatePendingAuth
+			i.LastError = err.Error()
+		}); !ok {
+			return
+		}
+		logger.Info("Queued submission is waiting for Spotify auth", fields...)
+		r.client.triggerAuthIfNeeded(ctx, sub.UserID,
+			fmt.Sprintf("⚠️ <@%s> Spotify auth needed — I've sent you a private login link.", sub.UserID), nil)
+
+	case transient(err):
+		updated, ok := r.update(item.ID, func(i *retryqueue.Item) {
+			i.Attempts++
+			i.NextAttempt = time.Now().UTC().Add(retryBackoff(i.Attempts))
+			i.LastError = err.Error()
+		})
+		if !ok {
+			return
+		}
+		logger.With(zap.Error(err), zap.Time(zapkey.Next, updated.NextAttempt)).
+			Warn("Retry of queued submission failed; retrying later", fields...)
+
+	default:
+		if _, ok := r.update(item.ID, func(i *retryqueue.Item) {
+			i.Attempts++
+			i.State = retryqueue.StateFailed
+			i.LastError = err.Error()
+		}); !ok {
+			return
+		}
+		logger.With(zap.Error(err)).Error("Retry of queued submission failed", fields...)
+		r.client.reportToDiscord(ctx, errorMessage(err, "add-tracks", sub.UserID))
+		r.client.updateSubmission(ctx, sub, track.NewResults(sub.Links(), track.StatusFailed, err))
+	}
+}
+
+// expire gives up on a submission older than the TTL and tells the submitter
+func (r *retrier) expire(item retryqueue.Item) {
+	sub := item.Submission
+	if _, ok := r.update(item.ID, func(i *retryqueue.Item) {
+		i.State = retryqueue.StateFailed
+		i.LastError = ErrRetryExpired.Error()
+	}); !ok {
+		return
+	}
+	ctx := ctxutil.WithGuildID(r.ctx, sub.GuildID)
+	logger.Warn("Gave up on queued submission",
+		zap.String(zapkey.ID, item.ID),
+		zap.String(zapkey.UserID, sub.UserID),
+		zap.String(zapkey.Status, string(item.State)),
+		zap.Int(zapkey.Attempt, item.Attempts))
+	r.client.reportToDiscord(ctx, fmt.Sprintf(
+		"⌛ <@%s> Gave up adding %s; post it again to retry.", sub.UserID, strings.Join(sub.Links(), " ")))
+	r.client.updateSubmission(ctx, sub, track.NewResults(sub.Links(), track.StatusFailed, ErrRetryExpired))
+}
+
+// update applies change to a queued submission and returns it, logging if the change could not
+// be saved. Returns false if the submission was withdrawn in the meantime, in which case it is
+// left as it is and its outcome must not be reported.
+func (r *retrier) update(id string, change func(*retryqueue.Item)) (retryqueue.Item, bool) {
+	item, err := r.queue.Update(id, change)
+	if errors.Is(err, retryqueue.ErrCancelled) {
+		logger.Info("Queued submission was withdrawn while being retried", zap.String(zapkey.ID, id))
+		return item, false
+	}
+	if err != nil {
+		logger.Error("Failed to update queued submission", zap.Error(err), zap.String(zapkey.ID, id))
+	}
+	return item, true
+}
+
+// retryBackoff returns the wait before the retry following the given number of failed attempts
+func retryBackoff(attempts int) time.Duration {
+	return min(retryBackoffBase<<min(attempts, 16), retryBackoffMax)
+}
+
+// transient reports whether err may go away by itself. Worker or network trouble, timeouts, rate
+// limits and Spotify outages are worth retrying; anything else, e.g. Spotify rejecting a request
+// because the playlist is gone or the worker refusing the bot's credentials, is final.
+func transient(err error) bool {
+	switch {
+	case errors.Is(err, worker.ErrUnavailable), errors.Is(err, worker.ErrRateLimited), errors.Is(err, ErrRateLimited):
+		return true
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) {
+		return spotifyErr.Status == http.StatusTooManyRequests || spotifyErr.Status >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,7 @@
+package retryqueue
+
+import (
+	"discordbot/log"
+)
+
+var logger = log.Logger.Named("retryqueue")
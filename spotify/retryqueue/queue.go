@@ -0,0 +1,248 @@
+// Package retryqueue keeps track submissions that could not be added yet, so they can be retried
+// later, including after a restart
+package retryqueue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/track"
+)
+
+// State is where a queued submission is in its life
+type State string
+
+const (
+	// StatePendingAuth means the submission waits for the submitter to connect Spotify
+	StatePendingAuth State = "pending-auth"
+
+	// StateRetrying means the submission failed for now and will be retried
+	StateRetrying State = "retrying"
+
+	// StateFailed means the submission was given up on
+	StateFailed State = "failed"
+
+	// StateDone means the submission was added
+	StateDone State = "done"
+
+	// StateCancelled means the submission was withdrawn, e.g. because its message was deleted
+	StateCancelled State = "cancelled"
+)
+
+var (
+	// ErrNotFound is returned when updating an item that is not in the queue
+	ErrNotFound = errors.New("no such queued submission")
+
+	// ErrCancelled is returned when updating an item that was cancelled
+	ErrCancelled = errors.New("queued submission was cancelled")
+)
+
+// Item is a queued submission
+type Item struct {
+	ID          string           `json:"id"`
+	Submission  track.Submission `json:"submission"`
+	State       State            `json:"state"`
+	Attempts    int              `json:"attempts"`             // Retries made so far
+	LastError   string           `json:"last_error,omitempty"` // Why the last attempt failed
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	NextAttempt time.Time        `json:"next_attempt"` // When a retrying submission is due
+}
+
+// Settled reports whether the item has reached a final state
+func (i Item) Settled() bool {
+	return i.State == StateFailed || i.State == StateDone || i.State == StateCancelled
+}
+
+// Queue holds queued submissions. If the queue has a path, every change is saved to that file so
+// the queue survives restarts.
+type Queue struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]Item // Keyed by item ID
+
+	saveMu sync.Mutex
+}
+
+// Open creates a queue, loading it from path if given. A missing file is an empty queue.
+func Open(path string) (*Queue, error) {
+	q := &Queue{path: path, items: make(map[string]Item)}
+	if path == "" {
+		return q, nil
+	}
+	if err := q.load(); err != nil {
+		return nil, fmt.Errorf("loading retry queue: %w", err)
+	}
+	logger.Info("Retry queue loaded", zap.String(zapkey.Path, path), zap.Int(zapkey.Count, len(q.items)))
+	return q, nil
+}
+
+// Add queues a submission in the given state, due for a retry at next, with lastErr as the reason
+// it could not be added. If the queue cannot be saved, the submission is not queued.
+func (q *Queue) Add(sub track.Submission, state State, next time.Time, lastErr string) (Item, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return Item{}, fmt.Errorf("failed to generate retry ID: %w", err)
+	}
+	now := time.Now().UTC()
+	item := Item{
+		ID:          hex.EncodeToString(b),
+		Submission:  sub,
+		State:       state,
+		LastError:   lastErr,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		NextAttempt: next.UTC(),
+	}
+
+	q.mu.Lock()
+	q.items[item.ID] = item
+	q.mu.Unlock()
+	if err := q.save(); err != nil {
+		// Nobody may be told the submission is queued if it would be lost on restart
+		q.mu.Lock()
+		delete(q.items, item.ID)
+		q.mu.Unlock()
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// Update applies change to the item with the given ID and returns the updated item. Returns
+// ErrNotFound if there is no such item, and ErrCancelled without applying change if the item was
+// cancelled, so work that raced the cancellation cannot bring it back. If the queue cannot be saved, the change is kept in
+// memory and the error returned, so it is lost on restart unless a later save succeeds.
+func (q *Queue) Update(id string, change func(*Item)) (Item, error) {
+	q.mu.Lock()
+	item, ok := q.items[id]
+	if !ok {
+		q.mu.Unlock()
+		return Item{}, ErrNotFound
+	}
+	if item.State == StateCancelled {
+		q.mu.Unlock()
+		return item, ErrCancelled
+	}
+	change(&item)
+	item.UpdatedAt = time.Now().UTC()
+	q.items[id] = item
+	q.mu.Unlock()
+	return item, q.save()
+}
+
+// List returns the items in any of the given states, oldest first
+func (q *Queue) List(states ...State) []Item {
+	q.mu.Lock()
+	var items []Item
+	for _, item := range q.items {
+		if slices.Contains(states, item.State) {
+			items = append(items, item)
+		}
+	}
+	q.mu.Unlock()
+	slices.SortFunc(items, func(a, b Item) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return items
+}
+
+// Prune drops settled items last changed before cutoff and returns how many were dropped
+func (q *Queue) Prune(cutoff time.Time) (int, error) {
+	q.mu.Lock()
+	var pruned int
+	for id, item := range q.items {
+		if item.Settled() && item.UpdatedAt.Before(cutoff) {
+			delete(q.items, id)
+			pruned++
+		}
+	}
+	q.mu.Unlock()
+	if pruned == 0 {
+		return 0, nil
+	}
+	return pruned, q.save()
+}
+
+// --- Persistence ---
+
+// file is the format the queue is saved in
+type file struct {
+	Items []Item `json:"items"`
+}
+
+// load reads the queue file
+func (q *Queue) load() error {
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	for _, item := range f.Items {
+		q.items[item.ID] = item
+	}
+	return nil
+}
+
+// save writes the queue to its file, if it has one. The file is replaced atomically so a crash
+// mid-write leaves the previous version.
+func (q *Queue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	q.mu.Lock()
+	f := file{Items: make([]Item, 0, len(q.items))}
+	for _, item := range q.items {
+		f.Items = append(f.Items, item)
+	}
+	q.mu.Unlock()
+	slices.SortFunc(f.Items, func(a, b Item) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	if err := writeFile(q.path, f); err != nil {
+		return fmt.Errorf("saving retry queue: %w", err)
+	}
+	return nil
+}
+
+// writeFile atomically replaces the file at path with f encoded as JSON
+func writeFile(path string, f file) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding retry queue: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating retry queue directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating retry queue file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing retry queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing retry queue: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
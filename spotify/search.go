@@ -0,0 +1,59 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jdcukier/spotify/v2"
+
+	"discordbot/spotify/track"
+)
+
+// -- Search ---
+
+// errNoMatch is the error for submitted searches that found no track
+var errNoMatch = errors.New("no tracks found")
+
+// SearchTracks searches the Spotify catalog for tracks matching query, using the given
+// Discord user's Spotify session. Auth is not triggered; callers decide how to handle
+// worker.ErrAuthRequired since searches also back autocomplete.
+func (c *Client) SearchTracks(ctx context.Context, userID, query string, limit int) ([]track.Summary, error) {
+	if query == "" {
+		return nil, nil
+	}
+	return searchTracks(ctx, c.spotifyClientForUser(userID), query, limit)
+}
+
+// resolveQuery searches for the track of a submission made without links and returns the
+// submission with the best match as its link. Other submissions are returned unchanged.
+func (c *Client) resolveQuery(ctx context.Context, sub track.Submission) (track.Submission, error) {
+	if len(sub.TrackURLs) > 0 || sub.Query == "" {
+		return sub, nil
+	}
+	matches, err := searchTracks(ctx, c.spotifyClientForUser(sub.UserID), sub.Query, 1)
+	if err != nil {
+		return sub, err
+	}
+	if len(matches) == 0 {
+		return sub, fmt.Errorf("%w for %q", errNoMatch, sub.Query)
+	}
+	sub.TrackURLs = []string{matches[0].URL()}
+	return sub, nil
+}
+
+// searchTracks searches the Spotify catalog for tracks matching query
+func searchTracks(ctx context.Context, api *spotify.Client, query string, limit int) ([]track.Summary, error) {
+	result, err := api.Search(ctx, query, spotify.SearchTypeTrack, spotify.Limit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("searching tracks: %w", err)
+	}
+	if result.Tracks == nil {
+		return nil, nil
+	}
+	summaries := make([]track.Summary, 0, len(result.Tracks.Tracks))
+	for _, t := range result.Tracks.Tracks {
+		summaries = append(summaries, track.NewSummary(t))
+	}
+	return summaries, nil
+}
@@ -0,0 +1,189 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+	"discordbot/spotify/worker"
+)
+
+const (
+	// tokenExpiryBuffer is how long before expiry a cached token stops being handed out, so it
+	// does not expire mid-request
+	tokenExpiryBuffer = 30 * time.Second
+
+	// tokenRefreshLead is how long before expiry a token in use is refreshed in the background
+	tokenRefreshLead = 2 * time.Minute
+
+	// tokenFetchTimeout bounds a fetch from the worker, which outlives the callers waiting on it
+	tokenFetchTimeout = 15 * time.Second
+)
+
+// tokenFetch is a fetch from the worker that callers for the same user wait on together
+type tokenFetch struct {
+	done  chan struct{}
+	token *worker.TokenData
+	err   error
+}
+
+// tokenEntry is the cached token of one user
+type tokenEntry struct {
+	token *worker.TokenData
+	used  bool        // Handed out since it was fetched; unused tokens are dropped rather than refreshed
+	fetch *tokenFetch // Fetch in progress; nil if none
+	timer *time.Timer // Refreshes the token before it expires
+}
+
+// tokenCache holds the Spotify access tokens of users, fetched from the worker, for every request
+// made on their behalf. Tokens are reused until shortly before they expire, and tokens in use are
+// refreshed in the background before then. Concurrent fetches for a user share one worker call.
+type tokenCache struct {
+	worker *worker.Client
+
+	mu      sync.Mutex
+	entries map[string]*tokenEntry // Keyed by Discord user ID
+}
+
+// newTokenCache creates an empty token cache fetching tokens from workerClient
+func newTokenCache(workerClient *worker.Client) *tokenCache {
+	return &tokenCache{worker: workerClient, entries: make(map[string]*tokenEntry)}
+}
+
+// get returns a token for the user, fetching one from the worker if none is cached
+func (c *tokenCache) get(ctx context.Context, userID string) (*worker.TokenData, error) {
+	c.mu.Lock()
+	entry := c.entry(userID)
+	entry.used = true
+	if entry.token != nil && !entry.token.IsExpired(tokenExpiryBuffer) {
+		token := entry.token
+		c.mu.Unlock()
+		return token, nil
+	}
+	fetch := c.start(userID, entry, c.worker.GetToken)
+	c.mu.Unlock()
+
+	token, err := c.wait(ctx, fetch)
+	if err != nil {
+		return nil, fmt.Errorf("fetching token from worker: %w", err)
+	}
+	return token, nil
+}
+
+// refresh makes the worker refresh the user's token, after Spotify rejected stale. If the token
+// was already replaced, e.g. by a concurrent refresh, the replacement is returned instead.
+func (c *tokenCache) refresh(ctx context.Context, userID string, stale *worker.TokenData) (*worker.TokenData, error) {
+	c.mu.Lock()
+	entry := c.entry(userID)
+	if entry.token != nil && entry.token != stale && !entry.token.IsExpired(tokenExpiryBuffer) {
+		token := entry.token
+		c.mu.Unlock()
+		return token, nil
+	}
+	entry.token = nil
+	entry.used = true
+	fetch := c.start(userID, entry, c.worker.ForceRefresh)
+	c.mu.Unlock()
+
+	return c.wait(ctx, fetch)
+}
+
+// invalidate drops the user's token, e.g. because they are authenticating again. A fetch in
+// progress is not cached when it completes.
+func (c *tokenCache) invalidate(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[userID]; ok {
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+		delete(c.entries, userID)
+	}
+}
+
+// entry returns the user's entry, creating it if needed. c.mu must be held.
+func (c *tokenCache) entry(userID string) *tokenEntry {
+	entry, ok := c.entries[userID]
+	if !ok {
+		entry = &tokenEntry{}
+		c.entries[userID] = entry
+	}
+	return entry
+}
+
+// start starts fetching the user's token with fetchFn, unless a fetch is already in progress,
+// and returns the fetch to wait on. c.mu must be held.
+func (c *tokenCache) start(userID string, entry *tokenEntry, fetchFn func(context.Context, string) (*worker.TokenData, error)) *tokenFetch {
+	if entry.fetch != nil {
+		return entry.fetch
+	}
+	fetch := &tokenFetch{done: make(chan struct{})}
+	entry.fetch = fetch
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenFetchTimeout)
+		defer cancel()
+		fetch.token, fetch.err = fetchFn(ctx, userID)
+
+		c.mu.Lock()
+		entry.fetch = nil
+		// Only cache the token if the entry was not invalidated while fetching
+		if c.entries[userID] == entry && fetch.err == nil {
+			entry.token = fetch.token
+			c.schedule(userID, entry)
+		}
+		c.mu.Unlock()
+		close(fetch.done)
+	}()
+	return fetch
+}
+
+// wait waits for fetch to complete or ctx to be done
+func (c *tokenCache) wait(ctx context.Context, fetch *tokenFetch) (*worker.TokenData, error) {
+	select {
+	case <-fetch.done:
+		return fetch.token, fetch.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// schedule arranges for the entry's token to be refreshed before it expires. c.mu must be held.
+func (c *tokenCache) schedule(userID string, entry *tokenEntry) {
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	delay := time.Until(time.Unix(entry.token.ExpiresAt, 0)) - tokenRefreshLead
+	entry.timer = time.AfterFunc(max(delay, 0), func() { c.refreshInBackground(userID, entry) })
+}
+
+// refreshInBackground fetches a new token for the entry if its token was used since it was last
+// fetched. Tokens nobody used are dropped, so idle users do not keep the worker busy.
+func (c *tokenCache) refreshInBackground(userID string, entry *tokenEntry) {
+	c.mu.Lock()
+	if c.entries[userID] != entry || entry.fetch != nil {
+		c.mu.Unlock()
+		return
+	}
+	if !entry.used {
+		delete(c.entries, userID)
+		c.mu.Unlock()
+		return
+	}
+	entry.used = false
+	fetch := c.start(userID, entry, c.worker.ForceRefresh)
+	c.mu.Unlock()
+
+	<-fetch.done
+	if fetch.err != nil {
+		// The token stays cached until it expires; the next request fetches a new one
+		logger.Warn("Failed to refresh Spotify token in the background",
+			zap.Error(fetch.err), zap.String(zapkey.UserID, userID))
+		return
+	}
+	logger.Debug("Refreshed Spotify token", zap.String(zapkey.UserID, userID))
+}
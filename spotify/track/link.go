@@ -0,0 +1,161 @@
+package track
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Kind is the kind of Spotify item a link refers to
+type Kind string
+
+const (
+	KindTrack    Kind = "track"
+	KindAlbum    Kind = "album"
+	KindPlaylist Kind = "playlist"
+	KindEpisode  Kind = "episode"
+	KindArtist   Kind = "artist"
+
+	// KindShort is a spotify.link or spoti.fi short link, which must be resolved to find out
+	// what it refers to
+	KindShort Kind = "short"
+)
+
+// Link is a Spotify link found in a message
+type Link struct {
+	Kind Kind
+	ID   string // Spotify ID; empty for short links
+
+	// Text is the link as written, and Start and End its byte offsets in the content
+	// it was found in. Wrapping angle brackets are included; trailing punctuation is not.
+	Text       string
+	Start, End int
+}
+
+// URL returns the canonical open.spotify.com URL of the link. Short links are returned as written.
+func (l Link) URL() string {
+	if l.Kind == KindShort {
+		return strings.Trim(l.Text, "<>")
+	}
+	return openBaseURL + string(l.Kind) + "/" + l.ID
+}
+
+const openBaseURL = "https://open.spotify.com/"
+
+var (
+	// candidateRegex matches text that may be a Spotify link: http(s) URLs, optionally wrapped in
+	// angle brackets to suppress embeds, and spotify: URIs
+	candidateRegex = regexp.MustCompile(`<https?://[^\s<>]+>|https?://[^\s<>]+|spotify:[A-Za-z0-9:]+`)
+
+	// idRegex matches a Spotify ID
+	idRegex = regexp.MustCompile(`^[A-Za-z0-9]{22}$`)
+
+	// intlRegex matches the locale segment of localized open.spotify.com paths, e.g. intl-de
+	intlRegex = regexp.MustCompile(`^intl-[A-Za-z-]+$`)
+)
+
+// trailingPunctuation is stripped from the end of URLs, where it usually belongs to the sentence
+// (or markdown) around the link rather than the link itself
+const trailingPunctuation = `.,;:!?'")]}*_~|`
+
+// openHosts are the hosts that serve full Spotify links
+var openHosts = map[string]bool{
+	"open.spotify.com": true,
+	"play.spotify.com": true,
+}
+
+// shortHosts are the hosts that serve Spotify short links
+var shortHosts = map[string]bool{
+	"spotify.link": true,
+	"spoti.fi":     true,
+}
+
+// kinds are the link kinds that refer to a Spotify ID
+var kinds = map[Kind]bool{
+	KindTrack:    true,
+	KindAlbum:    true,
+	KindPlaylist: true,
+	KindEpisode:  true,
+	KindArtist:   true,
+}
+
+// ParseLinks returns the Spotify links found in content, in order of appearance
+func ParseLinks(content string) []Link {
+	var links []Link
+	for _, span := range candidateRegex.FindAllStringIndex(content, -1) {
+		start, end := span[0], span[1]
+		text := content[start:end]
+		if !strings.HasPrefix(text, "<") {
+			trimmed := strings.TrimRight(text, trailingPunctuation)
+			end -= len(text) - len(trimmed)
+			text = trimmed
+		}
+		link, ok := ParseLink(text)
+		if !ok {
+			continue
+		}
+		link.Start, link.End = start, end
+		links = append(links, link)
+	}
+	return links
+}
+
+// ParseLink parses a single Spotify URL or URI
+func ParseLink(s string) (Link, bool) {
+	text := s
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "<"), ">")
+	if strings.HasPrefix(s, "spotify:") {
+		return parseURI(s, text)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Link{}, false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	if shortHosts[host] {
+		if strings.Trim(u.Path, "/") == "" {
+			return Link{}, false
+		}
+		return Link{Kind: KindShort, Text: text, End: len(text)}, true
+	}
+	if !openHosts[host] {
+		return Link{}, false
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) > 0 && intlRegex.MatchString(segments[0]) {
+		segments = segments[1:]
+	}
+	if len(segments) > 0 && segments[0] == "embed" {
+		segments = segments[1:]
+	}
+	// Legacy playlist links include the owner: /user/<user>/playlist/<id>
+	if len(segments) == 4 && segments[0] == "user" {
+		segments = segments[2:]
+	}
+	if len(segments) < 2 {
+		return Link{}, false
+	}
+	return newLink(segments[0], segments[1], text)
+}
+
+// parseURI parses a spotify:<kind>:<id> URI, including legacy spotify:user:<user>:playlist:<id> URIs
+func parseURI(uri, text string) (Link, bool) {
+	parts := strings.Split(uri, ":")
+	if len(parts) == 5 && parts[1] == "user" {
+		parts = append(parts[:1], parts[3:]...)
+	}
+	if len(parts) != 3 {
+		return Link{}, false
+	}
+	return newLink(parts[1], parts[2], text)
+}
+
+// newLink creates a link if kind and id are valid
+func newLink(kind, id, text string) (Link, bool) {
+	if !kinds[Kind(kind)] || !idRegex.MatchString(id) {
+		return Link{}, false
+	}
+	return Link{Kind: Kind(kind), ID: id, Text: text, End: len(text)}, true
+}
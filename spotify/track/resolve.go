@@ -0,0 +1,90 @@
+package track
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	// maxRedirects is the most redirects followed when resolving a short link
+	maxRedirects = 10
+
+	// maxResolveBody is the most of a short link landing page read when looking for its target
+	maxResolveBody = 256 << 10
+)
+
+// openURLRegex finds an open.spotify.com URL in a short link landing page
+var openURLRegex = regexp.MustCompile(`https://open\.spotify\.com/[^\s"'<>?]+`)
+
+// Resolver resolves Spotify short links to the links they redirect to
+type Resolver struct {
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewResolver creates a resolver that gives up on a short link after timeout
+func NewResolver(timeout time.Duration) *Resolver {
+	r := &Resolver{timeout: timeout}
+	r.client = &http.Client{
+		// Stop as soon as a redirect leads to a full Spotify link
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if _, ok := ParseLink(req.URL.String()); ok {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	return r
+}
+
+// Resolve returns the link a short link refers to. Other links are returned unchanged.
+func (r *Resolver) Resolve(ctx context.Context, link Link) (Link, error) {
+	if link.Kind != KindShort {
+		return link, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.URL(), nil)
+	if err != nil {
+		return Link{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return Link{}, fmt.Errorf("failed to resolve short link %s: %w", link.URL(), err)
+	}
+	defer resp.Body.Close()
+
+	// Redirected to a full link
+	if location := resp.Header.Get("Location"); location != "" {
+		if target, ok := ParseLink(location); ok {
+			return resolved(link, target), nil
+		}
+	}
+
+	// Some short links land on a page that redirects with JavaScript; look for the target in the page
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResolveBody))
+	if err != nil {
+		return Link{}, fmt.Errorf("failed to read short link page: %w", err)
+	}
+	for _, candidate := range openURLRegex.FindAllString(string(body), -1) {
+		if target, ok := ParseLink(candidate); ok {
+			return resolved(link, target), nil
+		}
+	}
+	return Link{}, fmt.Errorf("short link %s does not lead to a Spotify item", link.URL())
+}
+
+// resolved returns target in place of the short link it was resolved from
+func resolved(short, target Link) Link {
+	target.Text, target.Start, target.End = short.Text, short.Start, short.End
+	return target
+}
@@ -0,0 +1,99 @@
+package track
+
+import (
+	"github.com/jdcukier/spotify/v2"
+
+	"discordbot/ledger"
+)
+
+// Status is the outcome of submitting a track to a playlist
+type Status string
+
+const (
+	// StatusAdded means the track was added to the playlist
+	StatusAdded Status = "added"
+
+	// StatusDuplicate means the track was already in the playlist
+	StatusDuplicate Status = "duplicate"
+
+	// StatusAuthPending means the track will be added once the submitter connects Spotify
+	StatusAuthPending Status = "auth_pending"
+
+	// StatusRetrying means adding the track failed for now and will be retried
+	StatusRetrying Status = "retrying"
+
+	// StatusFailed means the track could not be added
+	StatusFailed Status = "failed"
+
+	// StatusNeedsConfirmation means a link would add many tracks and the submitter must confirm
+	// before they are added
+	StatusNeedsConfirmation Status = "needs_confirmation"
+)
+
+// Submission is a set of track links submitted by a Discord user for a playlist
+type Submission struct {
+	UserID     string   `json:"user_id"`              // Discord user who submitted the tracks
+	GuildID    string   `json:"guild_id,omitempty"`   // Guild the submission was made in
+	ChannelID  string   `json:"channel_id"`           // Channel the submission was made in
+	MessageID  string   `json:"message_id,omitempty"` // Message containing the tracks; empty for slash commands
+	PlaylistID string   `json:"playlist_id"`          // Playlist to add the tracks to
+	TrackURLs  []string `json:"track_urls"`           // Spotify track, album and playlist links
+	Query      string   `json:"query,omitempty"`      // Search whose best match is added, for submissions without links
+	Confirmed  bool     `json:"confirmed,omitempty"`  // Add large albums and playlists without asking for confirmation
+
+	// Playlists the tracks are also added to once they are added to PlaylistID, e.g. for hashtags
+	TagPlaylistIDs []string `json:"tag_playlist_ids,omitempty"`
+}
+
+// Links returns what was submitted: the track links, or the search query if there are none
+func (s Submission) Links() []string {
+	if len(s.TrackURLs) == 0 && s.Query != "" {
+		return []string{s.Query}
+	}
+	return s.TrackURLs
+}
+
+// Result is the outcome of submitting a single track
+type Result struct {
+	TrackID  spotify.ID
+	Link     string // Link the track was submitted through, e.g. an album link
+	Status   Status
+	Err      error         // Set when Status is StatusFailed
+	Original *ledger.Entry // For duplicates, who originally added the track; nil if added outside the bot
+	Count    int           // For confirmations, the number of tracks the link would add
+
+	Source         string  // For links converted from another platform, the original link
+	SourcePlatform string  // For converted links, the name of the platform linked to
+	SourceSong     string  // For converted links, the linked song as the platform describes it
+	Match          string  // For converted links, the Spotify track found for the song
+	Confidence     float64 // For converted links, how sure we are the track is the linked song
+}
+
+// NewResults creates a result with the same outcome for each of the given links.
+// Album and playlist links get a single result with no track ID.
+func NewResults(urls []string, status Status, err error) []Result {
+	results := make([]Result, 0, len(urls))
+	for _, url := range urls {
+		results = append(results, Result{TrackID: spotify.ID(ExtractTrackID(url)), Link: url, Status: status, Err: err})
+	}
+	return results
+}
+
+// Summarize returns the status that best describes a set of results as a whole.
+// Failures take precedence, then pending auth or retry, then pending confirmation, then added;
+// a submission made up entirely of duplicates is a duplicate.
+func Summarize(results []Result) Status {
+	if len(results) == 0 {
+		return StatusFailed
+	}
+	counts := make(map[Status]int)
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	for _, status := range []Status{StatusFailed, StatusAuthPending, StatusRetrying, StatusNeedsConfirmation, StatusAdded} {
+		if counts[status] > 0 {
+			return status
+		}
+	}
+	return StatusDuplicate
+}
@@ -0,0 +1,50 @@
+package track
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jdcukier/spotify/v2"
+)
+
+// baseURL is the prefix of Spotify track links
+const baseURL = "https://open.spotify.com/track/"
+
+// Summary describes a track for display to users
+type Summary struct {
+	ID      spotify.ID
+	Name    string
+	Artists []string
+	Album   string
+}
+
+// NewSummary creates a summary of a Spotify track
+func NewSummary(t spotify.FullTrack) Summary {
+	artists := make([]string, 0, len(t.Artists))
+	for _, artist := range t.Artists {
+		artists = append(artists, artist.Name)
+	}
+	return Summary{ID: t.ID, Name: t.Name, Artists: artists, Album: t.Album.Name}
+}
+
+// URL returns the Spotify link to the track
+func (s Summary) URL() string {
+	return URL(s.ID)
+}
+
+// String returns the track as "Name — Artists (Album)"
+func (s Summary) String() string {
+	str := s.Name
+	if len(s.Artists) > 0 {
+		str = fmt.Sprintf("%s — %s", str, strings.Join(s.Artists, ", "))
+	}
+	if s.Album != "" {
+		str = fmt.Sprintf("%s (%s)", str, s.Album)
+	}
+	return str
+}
+
+// URL returns the Spotify link to the track with the given ID
+func URL(id spotify.ID) string {
+	return baseURL + string(id)
+}
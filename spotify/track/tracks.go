@@ -1,50 +1,62 @@
 package track
 
 import (
-	"regexp"
-	"strings"
-
 	"github.com/jdcukier/spotify/v2"
 )
 
-// extractURLs extracts all URLs from the given content using regex
-func extractURLs(content string) []string {
-	// Regex pattern to match URLs
-	urlRegex := regexp.MustCompile(`https?://[^\s]+`)
-	return urlRegex.FindAllString(content, -1)
+// submittable are the link kinds that are submitted to a playlist. Episode and artist links have
+// no tracks to add, but are submitted so the poster is told rather than ignored.
+var submittable = map[Kind]bool{
+	KindTrack:    true,
+	KindAlbum:    true,
+	KindPlaylist: true,
+	KindEpisode:  true,
+	KindArtist:   true,
+	KindShort:    true,
 }
 
-// ExtractURLs extracts all Spotify track URLs from the given content
+// ExtractURLs extracts all Spotify links from the given content. Links are returned in their
+// canonical open.spotify.com form; short links are returned as written and must be resolved
+// before use.
 func ExtractURLs(content string) ([]string, bool) {
-	urls := extractURLs(content)
-	var spotifyTracks []string
-
-	for _, url := range urls {
-		if strings.Contains(url, "open.spotify.com/track") {
-			spotifyTracks = append(spotifyTracks, url)
+	var urls []string
+	for _, link := range ParseLinks(content) {
+		if submittable[link.Kind] {
+			urls = append(urls, link.URL())
 		}
 	}
+	return urls, len(urls) > 0
+}
 
-	return spotifyTracks, len(spotifyTracks) > 0
+// extractID returns the ID of a Spotify link of the given kind, or "" if it is not one
+func extractID(url string, kind Kind) string {
+	link, ok := ParseLink(url)
+	if !ok || link.Kind != kind {
+		return ""
+	}
+	return link.ID
 }
 
 // ExtractTrackID extracts the track ID from a Spotify track URL
 func ExtractTrackID(url string) string {
-	// Regex to match Spotify track URLs and capture everything after '/track/' until the query indicator '?' or end
-	re := regexp.MustCompile(`open\.spotify\.com/track/([^?]+)`)
-	matches := re.FindStringSubmatch(url)
-	if len(matches) > 1 {
-		return matches[1]
-	}
-	return ""
+	return extractID(url, KindTrack)
+}
+
+// ExtractAlbumID extracts the album ID from a Spotify album URL
+func ExtractAlbumID(url string) string {
+	return extractID(url, KindAlbum)
+}
+
+// ExtractPlaylistID extracts the playlist ID from a Spotify playlist URL
+func ExtractPlaylistID(url string) string {
+	return extractID(url, KindPlaylist)
 }
 
 // ToTrackIDs converts Spotify track URLs to spotify.ID slice
 func ToTrackIDs(urls []string) []spotify.ID {
 	var trackIDs []spotify.ID
 	for _, trackURL := range urls {
-		trackID := ExtractTrackID(trackURL)
-		if trackID != "" {
+		if trackID := ExtractTrackID(trackURL); trackID != "" {
 			trackIDs = append(trackIDs, spotify.ID(trackID))
 		}
 	}
@@ -52,12 +64,19 @@ func ToTrackIDs(urls []string) []spotify.ID {
 }
 
 // FilterTracks returns a list of tracks that are not already in the provided set of existing IDs.
+// Tracks repeated within trackIDs are only returned once.
 func FilterTracks(existingIDs map[spotify.ID]struct{}, trackIDs []spotify.ID) []spotify.ID {
 	var filteredTracks []spotify.ID
+	seen := make(map[spotify.ID]struct{}, len(trackIDs))
 	for _, trackID := range trackIDs {
-		if _, ok := existingIDs[trackID]; !ok {
-			filteredTracks = append(filteredTracks, trackID)
+		if _, ok := existingIDs[trackID]; ok {
+			continue
+		}
+		if _, ok := seen[trackID]; ok {
+			continue
 		}
+		seen[trackID] = struct{}{}
+		filteredTracks = append(filteredTracks, trackID)
 	}
 	return filteredTracks
 }
@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jdcukier/spotify/v2"
 	"go.uber.org/zap"
 
 	"discordbot/constants/zapkey"
+	"discordbot/ledger"
 	"discordbot/log"
+	"discordbot/spotify/retryqueue"
 	"discordbot/spotify/track"
 	"discordbot/spotify/worker"
 	"discordbot/utils/ctxutil"
@@ -17,45 +20,109 @@ import (
 
 // -- Tracks ---
 
-// AddTracksToPlaylist adds tracks to a playlist from a list of track URLs.
-// If the user has no Spotify token, auth is triggered and the track is retried automatically.
-func (c *Client) AddTracksToPlaylist(ctx context.Context, userID, playlistID string, trackURLs []string) error {
-	err := c.doAddTracks(ctx, userID, playlistID, trackURLs)
+// AddTracksToPlaylist adds the submitted tracks to the submission's playlist and returns the
+// outcome for each track. If the user has no Spotify token, auth is triggered, the tracks are
+// reported as pending and retried automatically once the user connects. Submissions failing on
+// trouble that may pass, e.g. a Spotify outage, are reported as retrying and retried with backoff.
+// Either way the final outcome is delivered through the messenger's UpdateSubmission.
+// Submissions made with a search query rather than links have it resolved first, so a search
+// that needs auth is queued like any other submission.
+//
+// Once the tracks are in the submission's playlist they are added to its tag playlists too, with
+// the outcome for those appended as described by addToTagPlaylists. Auth and confirmation are
+// only asked for once, for the submission's playlist, and cover the tag playlists.
+func (c *Client) AddTracksToPlaylist(ctx context.Context, sub track.Submission) ([]track.Result, error) {
+	resolved, err := c.resolveQuery(ctx, sub)
+	var results []track.Result
+	if err == nil {
+		results, err = c.doAddTracks(ctx, resolved)
+	}
 
 	if err == nil {
-		return nil
+		return append(results, c.addToTagPlaylists(ctx, resolved)...), nil
 	}
 
 	if errors.Is(err, worker.ErrAuthRequired) {
-		c.handleAuthRequired(ctx, userID, playlistID, trackURLs)
-		return nil // Return nil because we've handled/queued the retry
+		if queueErr := c.handleAuthRequired(ctx, sub); queueErr != nil {
+			return track.NewResults(sub.Links(), track.StatusFailed, queueErr), queueErr
+		}
+		// Return nil because we've handled/queued the retry
+		return track.NewResults(sub.Links(), track.StatusAuthPending, nil), nil
+	}
+
+	// Trouble that may pass is retried in the background rather than reported as a failure
+	if transient(err) {
+		queueErr := c.retrier.enqueue(ctx, sub, retryqueue.StateRetrying, err)
+		if queueErr == nil {
+			logger.With(zap.Error(err)).Warn("Adding tracks failed; queued for retry", ctxutil.ZapFields(ctx)...)
+			return track.NewResults(sub.Links(), track.StatusRetrying, nil), nil
+		}
+		logger.With(zap.Error(queueErr)).Error("Failed to queue submission for retry", ctxutil.ZapFields(ctx)...)
 	}
 
-	c.handleSpotifyError(ctx, err, "add-tracks", userID)
-	return err
+	c.handleSpotifyError(ctx, err, "add-tracks", sub.UserID)
+	return track.NewResults(sub.Links(), track.StatusFailed, err), err
 }
 
-// handleAuthRequired notifies the user that Spotify auth is needed, then queues
-// the track-add operation to be retried automatically after auth completes.
-func (c *Client) handleAuthRequired(ctx context.Context, userID, playlistID string, trackURLs []string) {
-	c.reportToDiscord(ctx, fmt.Sprintf("⚠️ <@%s> Spotify auth needed...", userID))
+// addToTagPlaylists adds the tracks of sub to its tag playlists. Only the outcome that affects
+// the submission as a whole is returned: duplicates are expected in themed playlists and large
+// albums or playlists are confirmed for the submission's playlist, which covers the tag playlists,
+// so those results are left out. Failures are retried like any other submission.
+func (c *Client) addToTagPlaylists(ctx context.Context, sub track.Submission) []track.Result {
+	var results []track.Result
+	seen := map[string]struct{}{sub.PlaylistID: {}}
+	for _, playlistID := range sub.TagPlaylistIDs {
+		if _, ok := seen[playlistID]; ok {
+			continue
+		}
+		seen[playlistID] = struct{}{}
+
+		tagSub := sub
+		tagSub.PlaylistID = playlistID
+		tagSub.TagPlaylistIDs = nil
+		tagCtx, fields := ctxutil.WithZapFields(ctx, zap.String(zapkey.PlaylistID, playlistID))
+		logger.Info("Adding tracks to tag playlist", fields...)
 
-	c.triggerAuthIfNeeded(ctx, userID, func(authCtx context.Context) {
-		if retryErr := c.doAddTracks(authCtx, userID, playlistID, trackURLs); retryErr != nil {
-			c.reportToDiscord(authCtx, fmt.Sprintf("❌ <@%s> Could not add track: %v", userID, retryErr))
-			return
+		tagResults, err := c.AddTracksToPlaylist(tagCtx, tagSub)
+		if err != nil {
+			logger.With(zap.Error(err)).Error("Failed to add tracks to tag playlist", fields...)
+		}
+		for _, result := range tagResults {
+			switch result.Status {
+			case track.StatusFailed, track.StatusAuthPending, track.StatusRetrying:
+				results = append(results, result)
+			}
 		}
-		c.reportToDiscord(authCtx, fmt.Sprintf("✅ <@%s> Your track was added!", userID))
-	})
+	}
+	return results
 }
 
-// doAddTracks performs the raw Spotify API calls to add tracks. No auth retry logic.
-func (c *Client) doAddTracks(
-	ctx context.Context,
-	userID string,
-	playlistID string,
-	trackURLs []string,
-) error {
+// handleAuthRequired queues the track-add operation to be retried automatically after auth
+// completes, then triggers Spotify auth, telling the user once their login link is sent. The submission
+// stays queued if this auth flow fails, until the user connects or it expires. If the submission
+// cannot be queued, the user is told to post it again and the error is returned.
+func (c *Client) handleAuthRequired(ctx context.Context, sub track.Submission) error {
+	userID := sub.UserID
+	notice := fmt.Sprintf("⚠️ <@%s> Spotify auth needed — I've sent you a private login link.", userID)
+	queueErr := c.retrier.enqueue(ctx, sub, retryqueue.StatePendingAuth, worker.ErrAuthRequired)
+	if queueErr != nil {
+		logger.With(zap.Error(queueErr)).Error("Failed to queue submission until auth completes", ctxutil.ZapFields(ctx)...)
+		// The submission is lost whether or not the link gets through
+		c.reportToDiscord(ctx, fmt.Sprintf("⚠️ <@%s> Spotify auth needed, and I couldn't save your submission, "+
+			"so post it again once you've connected.", userID))
+		notice = fmt.Sprintf("🔑 <@%s> I've sent you a private Spotify login link.", userID)
+	}
+	c.triggerAuthIfNeeded(ctx, userID, notice, nil)
+	if queueErr != nil {
+		return fmt.Errorf("queueing submission until auth completes: %w", queueErr)
+	}
+	return nil
+}
+
+// doAddTracks performs the raw Spotify API calls to add tracks and returns the outcome for
+// each track. Added tracks are recorded in the ledger. No auth retry logic.
+func (c *Client) doAddTracks(ctx context.Context, sub track.Submission) ([]track.Result, error) {
+	userID, playlistID, trackURLs := sub.UserID, sub.PlaylistID, sub.TrackURLs
 	api := c.spotifyClientForUser(userID)
 
 	ctx, fields := ctxutil.WithZapFields(
@@ -69,7 +136,7 @@ func (c *Client) doAddTracks(
 	currentUser, err := c.currentUser(ctx, api)
 	if err != nil {
 		logger.With(zap.Error(err)).Error("Spotify authentication test failed", fields...)
-		return fmt.Errorf("spotify authentication failed: %w", err)
+		return nil, fmt.Errorf("spotify authentication failed: %w", err)
 	}
 
 	ctx, fields = ctxutil.WithZapFields(
@@ -83,44 +150,160 @@ func (c *Client) doAddTracks(
 		c.logPlaylistInfo(ctx, api, playlistID)
 	}
 
-	// Convert track URLs to track IDs
-	trackIDs := track.ToTrackIDs(trackURLs)
+	// Expand links into the tracks they refer to
+	groups, err := c.expandLinks(ctx, api, trackURLs)
+	if err != nil {
+		logger.With(zap.Error(err)).Error("Cannot expand links", fields...)
+		return nil, err
+	}
+	var trackIDs []spotify.ID
+	for _, group := range groups {
+		trackIDs = append(trackIDs, group.trackIDs...)
+	}
 
 	ctx, fields = ctxutil.WithZapFields(
 		ctx,
-		zap.String(zapkey.PlaylistID, playlistID),
-		zap.Strings(zapkey.TrackURLs, trackURLs),
 		zap.Any(zapkey.TrackIDs, trackIDs),
 	)
 
-	// Determine tracks that are already in the playlist to avoid duplicates
-	existingTrackIDs, err := c.allPlaylistTrackIDs(ctx, api, playlistID)
+	// Determine tracks that are already in the playlist to avoid duplicates. Submissions racing this
+	// one are caught when the batch is written, since writes check the playlist again.
+	existingTrackIDs, err := c.existingTrackIDs(ctx, api, playlistID)
 	if err != nil {
 		logger.With(zap.Error(err)).Error("Cannot access playlist tracks", fields...)
-		return fmt.Errorf("cannot access playlist tracks %s: %w", playlistID, err)
+		return nil, fmt.Errorf("cannot access playlist tracks %s: %w", playlistID, err)
+	}
+
+	var results []track.Result
+	var duplicateTrackIDs, filteredTrackIDs []spotify.ID
+	links := make(map[spotify.ID]string) // Link each new track was submitted through
+	for _, group := range groups {
+		if group.err != nil {
+			results = append(results, track.Result{Link: group.link, Status: track.StatusFailed, Err: group.err})
+			continue
+		}
+
+		var newTrackIDs []spotify.ID
+		for _, trackID := range track.FilterTracks(existingTrackIDs, group.trackIDs) {
+			if _, ok := links[trackID]; !ok {
+				newTrackIDs = append(newTrackIDs, trackID)
+			}
+		}
+
+		// Large albums and playlists are held back until the submitter confirms them
+		if group.collection && !sub.Confirmed && len(newTrackIDs) > c.config.ConfirmThreshold {
+			results = append(results, track.Result{
+				Link:   group.link,
+				Status: track.StatusNeedsConfirmation,
+				Count:  len(newTrackIDs),
+			})
+			continue
+		}
+
+		// Uncertain matches for links from other platforms are held back until the submitter confirms them
+		if group.uncertain && !sub.Confirmed && len(newTrackIDs) > 0 {
+			results = append(results, track.Result{
+				Link:           group.link,
+				Status:         track.StatusNeedsConfirmation,
+				Count:          len(newTrackIDs),
+				Source:         group.source,
+				SourcePlatform: group.sourcePlatform,
+				SourceSong:     group.sourceSong,
+				Match:          group.match,
+				Confidence:     group.confidence,
+			})
+			continue
+		}
+
+		// Tracks that were filtered out are already in the playlist
+		for _, trackID := range group.trackIDs {
+			if _, ok := existingTrackIDs[trackID]; ok {
+				results = append(results, track.Result{
+					TrackID:  trackID,
+					Link:     group.link,
+					Status:   track.StatusDuplicate,
+					Original: c.originalSubmission(playlistID, trackID),
+				})
+				duplicateTrackIDs = append(duplicateTrackIDs, trackID)
+			}
+		}
+		for _, trackID := range newTrackIDs {
+			links[trackID] = group.link
+			filteredTrackIDs = append(filteredTrackIDs, trackID)
+		}
 	}
-	filteredTrackIDs := track.FilterTracks(existingTrackIDs, trackIDs)
+	c.record(ctx, sub, ledger.KindDuplicate, "", duplicateTrackIDs)
 
 	// If no new tracks, return early
 	if len(filteredTrackIDs) == 0 {
 		logger.Info("No new tracks to add", fields...)
-		return nil
+		return results, nil
 	}
 	if verboseLogsEnabled {
 		logger.With(zap.Any(zapkey.TrackIDs, filteredTrackIDs)).Info("Filtered track IDs", fields...)
 	}
 
-	// Add tracks to playlist
-	snapshotID, err := api.AddTracksToPlaylist(
-		ctx,
-		spotify.ID(playlistID),
-		filteredTrackIDs...,
-	)
+	// Add tracks to playlist, together with other submissions to it arriving around the same time
+	batch := c.batcher.add(ctx, api, sub, filteredTrackIDs)
 
 	// Log detailed error information
-	if err != nil {
-		logger.With(zap.Error(err), zap.String(zapkey.Data, snapshotID)).Error("Spotify API error", fields...)
+	if batch.err != nil {
+		logger.With(zap.Error(batch.err)).Error("Spotify API error", fields...)
+		return nil, batch.err
+	}
+
+	for _, trackID := range batch.added {
+		results = append(results, track.Result{TrackID: trackID, Link: links[trackID], Status: track.StatusAdded})
 	}
 
-	return err
+	// Tracks another submission added first
+	for _, trackID := range batch.duplicates {
+		results = append(results, track.Result{
+			TrackID:  trackID,
+			Link:     links[trackID],
+			Status:   track.StatusDuplicate,
+			Original: c.originalSubmission(playlistID, trackID),
+		})
+	}
+	c.record(ctx, sub, ledger.KindDuplicate, "", batch.duplicates)
+	return results, nil
+}
+
+// originalSubmission returns the ledger entry for when the track was first added to the playlist
+// by the bot, or nil if it was added some other way.
+func (c *Client) originalSubmission(playlistID string, trackID spotify.ID) *ledger.Entry {
+	if c.ledger == nil {
+		return nil
+	}
+	entry, ok := c.ledger.Find(playlistID, string(trackID))
+	if !ok {
+		return nil
+	}
+	return &entry
+}
+
+// record records what happened to a submission's tracks in the ledger. Failures are logged
+// rather than returned since the playlist has already been updated.
+func (c *Client) record(ctx context.Context, sub track.Submission, kind ledger.Kind, snapshotID string, trackIDs []spotify.ID) {
+	if c.ledger == nil || len(trackIDs) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	entries := make([]ledger.Entry, 0, len(trackIDs))
+	for _, trackID := range trackIDs {
+		entries = append(entries, ledger.Entry{
+			Kind:       kind,
+			TrackID:    string(trackID),
+			PlaylistID: sub.PlaylistID,
+			SnapshotID: snapshotID,
+			UserID:     sub.UserID,
+			GuildID:    sub.GuildID,
+			ChannelID:  sub.ChannelID,
+			MessageID:  sub.MessageID,
+			AddedAt:    now,
+		})
+	}
+	if err := c.ledger.Record(entries...); err != nil {
+		logger.With(zap.Error(err), zap.String(zapkey.Kind, string(kind))).Error("Failed to record tracks in ledger", ctxutil.ZapFields(ctx)...)
+	}
 }
@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// breakerState is the state of a circuit breaker
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // Calls go through
+	breakerOpen                         // Calls fail fast until the cooldown is over
+	breakerHalfOpen                     // One trial call goes through to see if the worker is back
+)
+
+// breaker stops calls to the worker once it keeps failing, so callers fail fast instead of each
+// waiting out timeouts and retries. After a cooldown, one trial call is let through; if it
+// succeeds calls flow again, otherwise the breaker stays open for another cooldown.
+type breaker struct {
+	threshold int           // Consecutive failures that open the breaker; 0 disables it
+	cooldown  time.Duration // How long the breaker stays open before a trial call
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // Consecutive failures while closed
+	openedAt time.Time // When the breaker last opened
+}
+
+// allow reports whether a call may go through now
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// Only the trial call goes through
+		return false
+	default:
+		return true
+	}
+}
+
+// record records the outcome of a call that was allowed through. Returns true if the call
+// opened the breaker.
+func (b *breaker) record(failed bool) bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.state = breakerClosed
+		b.failures = 0
+		return false
+	}
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		return false
+	}
+	b.failures++
+	if b.state == breakerClosed && b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.failures = 0
+		return true
+	}
+	return false
+}
+
+// abandon records that a call allowed through was cancelled before its outcome was known, which
+// says nothing about the worker. A cancelled trial call lets the next caller make another.
+func (b *breaker) abandon() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		// openedAt is past the cooldown, so the next call is let through as the trial
+		b.state = breakerOpen
+	}
+}
+
+// open reports whether the breaker is refusing calls
+func (b *breaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
@@ -7,15 +7,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
+	"slices"
 	"time"
-)
 
-// ErrAuthRequired is returned when no token exists for the user (HTTP 404 from worker),
-// or when the refresh token is invalid (HTTP 502 from worker, meaning Spotify rejected it).
-// The caller should trigger the full OAuth flow on receiving this error.
-var ErrAuthRequired = errors.New("spotify authentication required")
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
+)
 
 // TokenData is the token structure stored/returned by the worker.
 // expires_at is a Unix timestamp set by the worker at write time.
@@ -33,65 +34,92 @@ func (t *TokenData) IsExpired(buffer time.Duration) bool {
 	return time.Now().Add(buffer).Unix() >= t.ExpiresAt
 }
 
+// Defaults for calling the worker
+const (
+	DefaultTimeout          = 10 * time.Second // Longest a single call may take
+	DefaultRetries          = 2                // Retries of a call failing on trouble that may pass
+	DefaultBreakerThreshold = 5                // Consecutive failures before calls fail fast
+	DefaultBreakerCooldown  = 30 * time.Second // How long calls fail fast before the worker is tried again
+
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
 // Client is an HTTP client for the Cloudflare Worker API.
 // Authentication is via Cloudflare Access service token headers.
+//
+// Calls failing because the worker is unavailable or rate limiting are retried with backoff.
+// If calls keep failing, a circuit breaker makes further calls fail fast with ErrUnavailable
+// until the worker recovers.
 type Client struct {
 	baseURL              string
 	cfAccessClientID     string
 	cfAccessClientSecret string
 	httpClient           *http.Client
+	retries              int
+	breaker              *breaker
+}
+
+// Option is a function that configures a Client
+type Option func(*Client)
+
+// WithTimeout sets the longest a single call to the worker may take
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+// WithRetries sets how many times a call failing on trouble that may pass is retried
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = max(retries, 0)
+	}
+}
+
+// WithBreaker sets how many consecutive failures make calls fail fast, and for how long.
+// A threshold of 0 disables the circuit breaker.
+func WithBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.breaker = &breaker{threshold: threshold, cooldown: cooldown}
+	}
 }
 
 // NewClient creates a new worker API client.
-func NewClient(baseURL, cfClientID, cfClientSecret string) *Client {
-	return &Client{
+func NewClient(baseURL, cfClientID, cfClientSecret string, opts ...Option) *Client {
+	c := &Client{
 		baseURL:              baseURL,
 		cfAccessClientID:     cfClientID,
 		cfAccessClientSecret: cfClientSecret,
-		httpClient:           &http.Client{Timeout: 10 * time.Second},
-	}
+		httpClient: &http.Client{
+			Timeout: DefaultTimeout,
+			// Cloudflare Access answers bad credentials with a redirect to its login page
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		retries: DefaultRetries,
+		breaker: &breaker{threshold: DefaultBreakerThreshold, cooldown: DefaultBreakerCooldown},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// do executes a request with CF Access service token headers attached.
-func (c *Client) do(req *http.Request) (*http.Response, error) {
-	req.Header.Set("CF-Access-Client-Id", c.cfAccessClientID)
-	req.Header.Set("CF-Access-Client-Secret", c.cfAccessClientSecret)
-	return c.httpClient.Do(req)
+// Healthy reports whether the worker is taking calls, i.e. the circuit breaker is closed
+func (c *Client) Healthy() bool {
+	return !c.breaker.open()
 }
 
 // GetAuthURL fetches a signed Spotify OAuth URL from the worker for userID.
 // The bot posts this URL to Discord; after the user clicks it, the worker handles
 // the OAuth callback and stores the token in KV.
 func (c *Client) GetAuthURL(ctx context.Context, userID string) (string, error) {
-	u, err := url.Parse(c.baseURL + "/auth-url")
-	if err != nil {
-		return "", fmt.Errorf("parsing auth-url endpoint: %w", err)
-	}
-	q := u.Query()
-	q.Set("user_id", userID)
-	u.RawQuery = q.Encode()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-	if err != nil {
-		return "", fmt.Errorf("building auth-url request: %w", err)
-	}
-
-	resp, err := c.do(req)
-	if err != nil {
-		return "", fmt.Errorf("auth-url request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("auth-url returned %d: %s", resp.StatusCode, body)
-	}
-
 	var result struct {
 		AuthURL string `json:"auth_url"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("decoding auth-url response: %w", err)
+	query := url.Values{"user_id": {userID}}
+	if err := c.call(ctx, "auth-url", http.MethodGet, "/auth-url", query, nil, &result); err != nil {
+		return "", err
 	}
 	return result.AuthURL, nil
 }
@@ -100,69 +128,125 @@ func (c *Client) GetAuthURL(ctx context.Context, userID string) (string, error)
 // The worker auto-refreshes if within 60s of expiry.
 // Returns ErrAuthRequired if no token exists yet (HTTP 404).
 func (c *Client) GetToken(ctx context.Context, userID string) (*TokenData, error) {
-	req, err := http.NewRequestWithContext(
-		ctx, http.MethodGet,
-		fmt.Sprintf("%s/token/%s", c.baseURL, url.PathEscape(userID)),
-		nil,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("building get-token request: %w", err)
+	var token TokenData
+	// 404: no token yet; caller should trigger OAuth flow
+	authStatuses := []int{http.StatusNotFound}
+	if err := c.call(ctx, "get-token", http.MethodGet, "/token/"+url.PathEscape(userID), nil, authStatuses, &token); err != nil {
+		return nil, err
 	}
+	return &token, nil
+}
 
-	resp, err := c.do(req)
-	if err != nil {
-		return nil, fmt.Errorf("get-token request: %w", err)
+// ForceRefresh forces the worker to refresh the token for userID.
+// Returns ErrAuthRequired if the refresh token is invalid (Spotify rejected it),
+// indicating the full OAuth flow must be re-triggered.
+func (c *Client) ForceRefresh(ctx context.Context, userID string) (*TokenData, error) {
+	var token TokenData
+	// 404: token was deleted (race); 502: Spotify rejected the refresh_token (revoked)
+	// Both require re-authentication.
+	authStatuses := []int{http.StatusNotFound, http.StatusBadGateway}
+	if err := c.call(ctx, "force-refresh", http.MethodPost, "/refresh/"+url.PathEscape(userID), nil, authStatuses, &token); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return &token, nil
+}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, ErrAuthRequired // No token yet; caller should trigger OAuth flow
+// DeleteToken deletes the stored token for userID, so the bot can no longer act on their
+// Spotify account until they authenticate again.
+// Returns ErrAuthRequired if no token exists (HTTP 404).
+func (c *Client) DeleteToken(ctx context.Context, userID string) error {
+	var result struct {
+		Message string `json:"message"`
 	}
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("get-token returned %d: %s", resp.StatusCode, body)
+	authStatuses := []int{http.StatusNotFound}
+	return c.call(ctx, "delete-token", http.MethodDelete, "/token/"+url.PathEscape(userID), nil, authStatuses, &result)
+}
+
+// call makes a call to the worker and decodes its JSON response into out. Responses with one of
+// authStatuses mean the user must authenticate again. Calls failing on trouble that may pass are
+// retried with backoff, as long as ctx allows and the circuit breaker lets them through.
+func (c *Client) call(ctx context.Context, op, method, path string, query url.Values, authStatuses []int, out any) error {
+	u, err := url.Parse(c.baseURL + path)
+	if err != nil {
+		return &Error{Op: op, Err: ErrMisconfigured, Cause: err}
 	}
+	u.RawQuery = query.Encode()
 
-	var token TokenData
-	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
-		return nil, fmt.Errorf("decoding token response: %w", err)
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, op, method, u.String(), authStatuses, out)
+		if err == nil || !transient(err) || attempt >= c.retries {
+			return err
+		}
+
+		delay := min(retryBaseDelay<<attempt, retryMaxDelay)
+		delay = delay/2 + rand.N(delay/2+1)
+		var workerErr *Error
+		if errors.As(err, &workerErr) && workerErr.RetryAfter > 0 {
+			delay = workerErr.RetryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		logger.Warn("Retrying worker call",
+			zap.Error(err), zap.String(zapkey.Operation, op),
+			zap.Int(zapkey.Attempt, attempt+1), zap.Duration(zapkey.Duration, delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
 	}
-	return &token, nil
 }
 
-// ForceRefresh forces the worker to refresh the token for userID.
-// Returns ErrAuthRequired if the refresh token is invalid (Spotify rejected it),
-// indicating the full OAuth flow must be re-triggered.
-func (c *Client) ForceRefresh(ctx context.Context, userID string) (*TokenData, error) {
-	req, err := http.NewRequestWithContext(
-		ctx, http.MethodPost,
-		fmt.Sprintf("%s/refresh/%s", c.baseURL, url.PathEscape(userID)),
-		nil,
-	)
+// attempt makes a single call to the worker, going through the circuit breaker
+func (c *Client) attempt(ctx context.Context, op, method, rawURL string, authStatuses []int, out any) error {
+	if !c.breaker.allow() {
+		return &Error{Op: op, Err: ErrUnavailable, Cause: errCircuitOpen}
+	}
+	err := c.do(ctx, op, method, rawURL, authStatuses, out)
+	if err != nil && ctx.Err() != nil {
+		// Cancelled calls show neither that the worker is down nor that it is up
+		c.breaker.abandon()
+		return err
+	}
+	// Only outages count against the worker; auth and configuration errors show it is up
+	failed := errors.Is(err, ErrUnavailable)
+	if c.breaker.record(failed) {
+		logger.Error("Spotify worker keeps failing; failing calls fast",
+			zap.Error(err), zap.Duration(zapkey.Duration, c.breaker.cooldown))
+	}
+	return err
+}
+
+// do sends a request to the worker with CF Access service token headers attached and
+// classifies the response
+func (c *Client) do(ctx context.Context, op, method, rawURL string, authStatuses []int, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("building force-refresh request: %w", err)
+		return &Error{Op: op, Err: ErrMisconfigured, Cause: err}
 	}
+	req.Header.Set("CF-Access-Client-Id", c.cfAccessClientID)
+	req.Header.Set("CF-Access-Client-Secret", c.cfAccessClientSecret)
 
-	resp, err := c.do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("force-refresh request: %w", err)
+		return &Error{Op: op, Err: ErrUnavailable, Cause: err}
 	}
 	defer resp.Body.Close()
 
+	if slices.Contains(authStatuses, resp.StatusCode) {
+		body, _ := io.ReadAll(resp.Body)
+		return &Error{Op: op, StatusCode: resp.StatusCode, Body: string(body), Err: ErrAuthRequired}
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		// 404: token was deleted (race); 502: Spotify rejected the refresh_token (revoked)
-		// Both require re-authentication.
-		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadGateway {
-			return nil, fmt.Errorf("%w: force-refresh returned %d: %s",
-				ErrAuthRequired, resp.StatusCode, body)
-		}
-		return nil, fmt.Errorf("force-refresh returned %d: %s", resp.StatusCode, body)
+		return statusError(op, resp, body)
 	}
-
-	var token TokenData
-	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
-		return nil, fmt.Errorf("decoding refresh response: %w", err)
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return &Error{Op: op, StatusCode: resp.StatusCode, Err: ErrMisconfigured, Cause: fmt.Errorf("decoding response: %w", err)}
 	}
-	return &token, nil
+	return nil
 }
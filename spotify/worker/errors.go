@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrAuthRequired is returned when no token exists for the user (HTTP 404 from worker),
+	// or when the refresh token is invalid (HTTP 502 from worker, meaning Spotify rejected it).
+	// The caller should trigger the full OAuth flow on receiving this error.
+	ErrAuthRequired = errors.New("spotify authentication required")
+
+	// ErrUnavailable is returned when the worker cannot be reached, fails with a 5xx, or has
+	// failed so often that the circuit breaker is refusing calls to it
+	ErrUnavailable = errors.New("spotify worker unavailable")
+
+	// ErrRateLimited is returned when the worker or Cloudflare turns the bot away for sending
+	// too many requests
+	ErrRateLimited = errors.New("spotify worker rate limited")
+
+	// ErrForbidden is returned when Cloudflare Access rejects the bot's service token
+	ErrForbidden = errors.New("spotify worker refused the Cloudflare Access credentials")
+
+	// ErrMisconfigured is returned when the worker's responses make no sense to the bot, e.g.
+	// because the worker URL points somewhere else
+	ErrMisconfigured = errors.New("spotify worker misconfigured")
+
+	// errCircuitOpen is the cause of ErrUnavailable when the circuit breaker refused a call
+	errCircuitOpen = errors.New("circuit breaker open")
+)
+
+// Error describes a failed call to the worker. It wraps one of the package's sentinel errors,
+// so callers can tell failures apart with errors.Is.
+type Error struct {
+	Op         string        // Call that failed, e.g. "get-token"
+	StatusCode int           // HTTP status returned by the worker; 0 if there was no response
+	Body       string        // Response body, if any
+	RetryAfter time.Duration // For rate limits, how long the worker asked to wait; 0 if unknown
+	Err        error         // Sentinel error classifying the failure
+	Cause      error         // Underlying error, e.g. the network error; nil if there was a response
+}
+
+// Error returns a description of the failure
+func (e *Error) Error() string {
+	switch {
+	case e.Cause != nil:
+		return fmt.Sprintf("%s: %v: %v", e.Op, e.Err, e.Cause)
+	case e.StatusCode != 0 && e.Body != "":
+		return fmt.Sprintf("%s returned %d: %v: %s", e.Op, e.StatusCode, e.Err, e.Body)
+	case e.StatusCode != 0:
+		return fmt.Sprintf("%s returned %d: %v", e.Op, e.StatusCode, e.Err)
+	default:
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+}
+
+// Unwrap returns the sentinel error and the underlying error
+func (e *Error) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
+
+// statusError classifies a non-200 response to op
+func statusError(op string, resp *http.Response, body []byte) *Error {
+	e := &Error{Op: op, StatusCode: resp.StatusCode, Body: string(body)}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Err = ErrRateLimited
+		e.RetryAfter = retryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode >= http.StatusInternalServerError:
+		e.Err = ErrUnavailable
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		e.Err = ErrForbidden
+	case resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode < http.StatusBadRequest:
+		// Cloudflare Access redirects requests without valid credentials to its login page
+		e.Err = ErrForbidden
+	default:
+		e.Err = ErrMisconfigured
+	}
+	return e
+}
+
+// transient reports whether a call failing with err may succeed if tried again soon. Calls the
+// circuit breaker refused are not, since it stays open for a while.
+func transient(err error) bool {
+	if errors.Is(err, errCircuitOpen) {
+		return false
+	}
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, ErrRateLimited)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date; 0 if absent
+func retryAfter(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	var seconds int
+	if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(val); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
@@ -0,0 +1,7 @@
+package worker
+
+import (
+	"discordbot/log"
+)
+
+var logger = log.Logger.Named("worker")
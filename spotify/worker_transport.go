@@ -2,25 +2,26 @@ package spotify
 
 import (
 	"bytes"
-	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"sync"
 
+	"go.uber.org/zap"
+
+	"discordbot/constants/zapkey"
 	"discordbot/spotify/worker"
+	"discordbot/utils/ctxutil"
 )
 
 // workerTransport is an http.RoundTripper that authenticates Spotify API requests
-// using access tokens fetched from the Cloudflare Worker.
+// using access tokens fetched from the Cloudflare Worker. Rate limited and failed requests
+// are retried with backoff for as long as the request's context allows.
 type workerTransport struct {
-	workerClient *worker.Client
-	userID       string
-	base         http.RoundTripper
-
-	mu    sync.Mutex
-	cache *worker.TokenData
+	tokens  *tokenCache // Shared by every transport, so tokens outlive the SDK clients using them
+	userID  string
+	base    http.RoundTripper
+	limiter *rateLimiter // Shared by every transport, since Spotify limits the app as a whole
 }
 
 func (t *workerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -35,18 +36,12 @@ func (t *workerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		bodyBytes = b
 	}
 
-	token, err := t.cachedToken(req.Context())
+	token, err := t.tokens.get(req.Context(), t.userID)
 	if err != nil {
 		return nil, err
 	}
 
-	req = req.Clone(req.Context())
-	if bodyBytes != nil {
-		req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-	}
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-
-	resp, err := t.base.RoundTrip(req)
+	resp, err := t.send(req, bodyBytes, token)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +49,7 @@ func (t *workerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if resp.StatusCode == http.StatusUnauthorized {
 		resp.Body.Close()
 
-		fresh, refreshErr := t.forceRefreshAndCache(req.Context())
+		fresh, refreshErr := t.tokens.refresh(req.Context(), t.userID, token)
 		if refreshErr != nil {
 			if errors.Is(refreshErr, worker.ErrAuthRequired) {
 				return nil, refreshErr
@@ -62,51 +57,62 @@ func (t *workerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 			return nil, fmt.Errorf("force-refresh after 401: %w", refreshErr)
 		}
 
-		req = req.Clone(req.Context())
-		if bodyBytes != nil {
-			req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-		}
-		req.Header.Set("Authorization", "Bearer "+fresh.AccessToken)
-		return t.base.RoundTrip(req)
+		return t.send(req, bodyBytes, fresh)
 	}
 
 	return resp, nil
 }
 
-func (t *workerTransport) cachedToken(ctx context.Context) (*worker.TokenData, error) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// send sends req with token, first waiting out any rate limit. Responses that are 429s, or 5xxs
+// to requests that are safe to repeat, are retried with backoff until the retries run out or the
+// next attempt would pass the request's deadline; the last response is then returned as is.
+func (t *workerTransport) send(req *http.Request, body []byte, token *worker.TokenData) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
 
-	if t.cache != nil && !t.cache.IsExpired(0) {
-		return t.cache, nil
-	}
+		out := req.Clone(ctx)
+		if body != nil {
+			out.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		out.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		resp, err := t.base.RoundTrip(out)
+		if err != nil {
+			return nil, err
+		}
+		if !retryable(out, resp) {
+			return resp, nil
+		}
 
-	token, err := t.workerClient.GetToken(ctx, t.userID)
-	if err != nil {
-		return nil, fmt.Errorf("fetching token from worker: %w", err)
-	}
+		// A 429 holds back every request, since the limit is the app's, even if this one gives up
+		delay := retryDelay(resp, attempt)
+		limited := resp.StatusCode == http.StatusTooManyRequests
+		if limited {
+			t.limiter.backoff(delay)
+		}
+		if attempt >= maxRetries || !fitsDeadline(ctx, delay) {
+			return resp, nil
+		}
 
-	t.cache = token
-	return token, nil
-}
+		// Drain the body so the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
 
-func (t *workerTransport) forceRefreshAndCache(ctx context.Context) (*worker.TokenData, error) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+		logger.With(
+			zap.String(zapkey.Method, out.Method),
+			zap.String(zapkey.Path, out.URL.Path),
+			zap.Int(zapkey.StatusCode, resp.StatusCode),
+			zap.Int(zapkey.Attempt, attempt+1),
+			zap.Duration(zapkey.Duration, delay),
+		).Warn("Retrying Spotify request", ctxutil.ZapFields(ctx)...)
 
-	t.cache = nil
-	fresh, err := t.workerClient.ForceRefresh(ctx, t.userID)
-	if err != nil {
-		return nil, err
+		if limited {
+			continue
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
 	}
-	t.cache = fresh
-	return fresh, nil
-}
-
-// ClearCache invalidates the in-memory token cache so the next request fetches
-// a fresh token from the worker. Called after successful re-authentication.
-func (t *workerTransport) ClearCache() {
-	t.mu.Lock()
-	t.cache = nil
-	t.mu.Unlock()
 }
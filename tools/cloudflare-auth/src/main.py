@@ -253,6 +253,23 @@ async def force_refresh(user_id: str, request: Request):
     return token_data
 
 
+@app.delete("/token/{user_id}")
+async def delete_token(user_id: str, request: Request):
+    """
+    Deletes the stored token for user_id, disconnecting their Spotify account from the bot.
+    Spotify keeps the app authorization until the user removes it from their account page.
+    Protected by Cloudflare Access (service token).
+    """
+    print(f"INFO: Deleting token for user id: {user_id}")
+    env = request.app.state.env
+    stored_json = await env.SPOTIFY_TOKENS.get(user_id)
+    if not stored_json:
+        raise HTTPException(status_code=404, detail="No token found for this user_id")
+
+    await env.SPOTIFY_TOKENS.delete(user_id)
+    return {"message": "Token deleted"}
+
+
 class Default(WorkerEntrypoint):
     async def fetch(self, request):
         import _asgi as asgi
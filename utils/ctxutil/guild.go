@@ -0,0 +1,26 @@
+package ctxutil
+
+import "context"
+
+// Define a unique key type for storing the guild ID in the context
+type guildIDKeyType struct{}
+
+// Instantiate the unique key for the guild ID in the context
+var guildIDKey = guildIDKeyType{}
+
+// WithGuildID injects the ID of the guild an action was triggered in into the context
+func WithGuildID(ctx context.Context, guildID string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, guildIDKey, guildID)
+}
+
+// GuildID retrieves the ID of the guild an action was triggered in, or "" if unknown
+func GuildID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	guildID, _ := ctx.Value(guildIDKey).(string)
+	return guildID
+}
@@ -0,0 +1,190 @@
+// Package workpool runs jobs on a bounded pool of workers, keeping jobs that share a key in order
+package workpool
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned when a job is submitted while the queue is full
+	ErrQueueFull = errors.New("work queue is full")
+
+	// ErrStopped is returned when a job is submitted after the pool was stopped
+	ErrStopped = errors.New("work pool is stopped")
+)
+
+// Job is a unit of work
+type Job func(ctx context.Context)
+
+// Stats is a snapshot of a pool's activity
+type Stats struct {
+	Name      string `json:"name"`
+	Workers   int    `json:"workers"`
+	Capacity  int    `json:"capacity"`  // Most jobs that can wait or run at once
+	Queued    int    `json:"queued"`    // Jobs waiting for a worker or for earlier jobs with the same key
+	Running   int    `json:"running"`   // Jobs being run
+	Completed uint64 `json:"completed"` // Jobs run since the pool started
+	Rejected  uint64 `json:"rejected"`  // Jobs refused because the queue was full
+}
+
+// job is a submitted job
+type job struct {
+	ctx     context.Context
+	run     Job
+	keys    []string
+	blocked int // Number of keys with an earlier job still pending
+}
+
+// Pool runs jobs on a fixed number of workers. Jobs sharing a key run one at a time, in the
+// order they were submitted; jobs with no key in common run concurrently.
+type Pool struct {
+	name     string
+	workers  int
+	capacity int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	ready   []*job            // Jobs that can run now, in submission order
+	byKey   map[string][]*job // Pending jobs by key, in submission order
+	pending int               // Jobs queued or running
+	running int
+	stopped bool
+	stats   Stats
+
+	wg sync.WaitGroup
+}
+
+// New creates a pool named name with the given number of workers. At most capacity jobs can be
+// queued or running at once.
+func New(name string, workers, capacity int) *Pool {
+	p := &Pool{
+		name:     name,
+		workers:  max(workers, 1),
+		capacity: max(capacity, 1),
+		byKey:    make(map[string][]*job),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// String returns a string representation of the pool
+func (p *Pool) String() string {
+	return p.name + " Pool"
+}
+
+// Start starts the workers
+func (p *Pool) Start() error {
+	for range p.workers {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return nil
+}
+
+// Stop stops accepting jobs and waits for the queued and running jobs to finish
+func (p *Pool) Stop() error {
+	p.mu.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+// Submit queues run, to be run after every earlier job sharing one of keys. The job's context
+// keeps the values of ctx but not its cancellation, since the job outlives the caller.
+func (p *Pool) Submit(ctx context.Context, keys []string, run Job) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return ErrStopped
+	}
+	if p.pending >= p.capacity {
+		p.stats.Rejected++
+		return ErrQueueFull
+	}
+
+	j := &job{ctx: context.WithoutCancel(ctx), run: run}
+	for _, key := range keys {
+		if key == "" || slices.Contains(j.keys, key) {
+			continue
+		}
+		j.keys = append(j.keys, key)
+		if len(p.byKey[key]) > 0 {
+			j.blocked++
+		}
+		p.byKey[key] = append(p.byKey[key], j)
+	}
+	p.pending++
+	if j.blocked == 0 {
+		p.ready = append(p.ready, j)
+		p.cond.Signal()
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the pool's activity
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Name = p.name
+	stats.Workers = p.workers
+	stats.Capacity = p.capacity
+	stats.Running = p.running
+	stats.Queued = p.pending - p.running
+	return stats
+}
+
+// work runs ready jobs until the pool is stopped and drained
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(p.ready) == 0 && !(p.stopped && p.pending == 0) {
+			p.cond.Wait()
+		}
+		if len(p.ready) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		j := p.ready[0]
+		p.ready = p.ready[1:]
+		p.running++
+		p.mu.Unlock()
+
+		j.run(j.ctx)
+
+		p.mu.Lock()
+		p.running--
+		p.pending--
+		p.stats.Completed++
+		p.release(j)
+		if p.stopped && p.pending == 0 {
+			p.cond.Broadcast()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// release removes a finished job from its keys, readying the jobs it was holding back.
+// p.mu must be held.
+func (p *Pool) release(j *job) {
+	for _, key := range j.keys {
+		queue := p.byKey[key][1:]
+		if len(queue) == 0 {
+			delete(p.byKey, key)
+			continue
+		}
+		p.byKey[key] = queue
+		next := queue[0]
+		next.blocked--
+		if next.blocked == 0 {
+			p.ready = append(p.ready, next)
+			p.cond.Signal()
+		}
+	}
+}